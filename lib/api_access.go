@@ -0,0 +1,190 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/kspeeder/docker-registry/lib/auth"
+	"github.com/kspeeder/docker-registry/lib/connector"
+)
+
+// AccessReport lists which of the requested actions the current credentials
+// grant on a repository.
+type AccessReport struct {
+	Repository string
+	Granted    []string
+	Denied     []string
+	// Unknown lists actions probes could not decide, e.g. pull on a missing
+	// repository or delete on a registry with deletion disabled.
+	Unknown []string
+	// Probed is set if the grants were derived from probe requests because
+	// the registry did not issue a token with access claims.
+	Probed bool
+}
+
+// Allowed is set if all requested actions are known to be granted.
+func (r AccessReport) Allowed() bool {
+	return len(r.Denied) == 0 && len(r.Unknown) == 0
+}
+
+// Err returns an AutorizationError describing the denied or undetermined
+// actions, or nil.
+func (r AccessReport) Err() error {
+	if r.Allowed() {
+		return nil
+	}
+
+	granted := "none"
+	if len(r.Granted) > 0 {
+		granted = strings.Join(r.Granted, ",")
+	}
+
+	if len(r.Denied) == 0 {
+		return AutorizationError(fmt.Sprintf("%s access to %s could not be determined (granted: %s)",
+			strings.Join(r.Unknown, ","), r.Repository, granted))
+	}
+
+	return AutorizationError(fmt.Sprintf("%s access to %s denied (granted: %s)",
+		strings.Join(r.Denied, ","), r.Repository, granted))
+}
+
+// CheckAccess works out whether the current credentials grant the actions on
+// the repository, without changing it. Grants are read from the access claims
+// of a token requested for the actions; registries issuing opaque tokens or
+// not using tokens at all are probed instead. Access claims cannot tell
+// whether the registry has deletion disabled, so a granted delete may still
+// fail; probes report it as unknown then. Probing push opens an upload
+// session, so push stays unknown unless write probes are enabled with
+// Config.SetProbeWrites.
+func (r *registryApi) CheckAccess(ctx context.Context, repository string, actions ...string) (report AccessReport, err error) {
+	report.Repository = repository
+	ctx = r.requestContext(ctx, connector.OperationCheckAccess, repository)
+
+	if authorizer, ok := r.connector.(connector.ScopeAuthorizer); ok {
+		var token auth.Token
+		token, err = authorizer.AuthorizeScopes(ctx, r.endpointUrl("v2/"), auth.RepositoryScope(repository, actions...).String())
+		if err != nil {
+			return
+		}
+
+		if token != nil {
+			if access, ok := auth.TokenAccess(token.Value()); ok {
+				for _, action := range actions {
+					report.add(action, accessGranted(access, repository, action))
+				}
+				return
+			}
+		}
+	}
+
+	report.Probed = true
+	for _, action := range actions {
+		var granted, determined bool
+		granted, determined, err = r.probeAccess(ctx, repository, action)
+		if err != nil {
+			return
+		}
+
+		if determined {
+			report.add(action, granted)
+		} else {
+			report.Unknown = append(report.Unknown, action)
+		}
+	}
+
+	return
+}
+
+func (r *registryApi) CanPull(ctx context.Context, repository string) (bool, error) {
+	return r.can(ctx, repository, auth.ActionPull)
+}
+
+func (r *registryApi) CanPush(ctx context.Context, repository string) (bool, error) {
+	return r.can(ctx, repository, auth.ActionPush)
+}
+
+func (r *registryApi) CanDelete(ctx context.Context, repository string) (bool, error) {
+	return r.can(ctx, repository, auth.ActionDelete)
+}
+
+func (r *registryApi) can(ctx context.Context, repository, action string) (bool, error) {
+	report, err := r.CheckAccess(ctx, repository, action)
+	return err == nil && report.Allowed(), err
+}
+
+func (r *AccessReport) add(action string, granted bool) {
+	if granted {
+		r.Granted = append(r.Granted, action)
+	} else {
+		r.Denied = append(r.Denied, action)
+	}
+}
+
+func accessGranted(access []auth.Scope, repository, action string) bool {
+	for _, scope := range access {
+		if scope.Type != "repository" || scope.Name != repository {
+			continue
+		}
+
+		for _, granted := range scope.Actions {
+			if granted == action || granted == auth.ActionAll {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// probeAccess issues a request that needs the action but leaves the
+// repository unchanged. Responses that depend on more than the grant leave
+// the result undetermined, as does push without write probes.
+func (r *registryApi) probeAccess(ctx context.Context, repository, action string) (granted, determined bool, err error) {
+	var response *http.Response
+
+	switch action {
+	case auth.ActionPull:
+		tagsUrl := r.endpointUrl(fmt.Sprintf("v2/%s/tags/list", repository))
+		tagsUrl.RawQuery = "n=1"
+		response, err = r.probe(ctx, http.MethodGet, tagsUrl, nil, cacheHintTagList(repository))
+
+	case auth.ActionPush:
+		if !r.cfg.probeWrites {
+			return
+		}
+
+		uploadUrl := r.endpointUrl(fmt.Sprintf("v2/%s/blobs/uploads/", repository))
+		response, err = r.probe(ctx, http.MethodPost, uploadUrl, nil, cacheHintPush(repository))
+		if err == nil && response.StatusCode == http.StatusAccepted {
+			err = r.cancelUpload(ctx, repository, uploadUrl, response)
+		}
+
+	case auth.ActionDelete:
+		response, err = r.probe(ctx, http.MethodDelete,
+			r.endpointUrl(fmt.Sprintf("v2/%s/manifests/%s", repository, probeDigest)), nil, cacheHintDelete(repository))
+
+	default:
+		err = newInvalidRequestError(fmt.Sprintf("cannot probe %s access", action))
+	}
+
+	if err != nil {
+		return
+	}
+
+	switch {
+	case response.StatusCode == http.StatusUnauthorized, response.StatusCode == http.StatusForbidden:
+		determined = true
+	case response.StatusCode == http.StatusNotFound && action == auth.ActionPull:
+		// The repository does not exist, or the registry hides it.
+	case response.StatusCode == http.StatusMethodNotAllowed && action == auth.ActionDelete:
+		// Deletion is disabled.
+	case response.StatusCode >= http.StatusInternalServerError:
+		err = newRegistryError(response, newInvalidStatusCodeError(response.StatusCode))
+	default:
+		granted, determined = true, true
+	}
+
+	return
+}
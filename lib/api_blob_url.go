@@ -0,0 +1,48 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/kspeeder/docker-registry/lib/connector"
+)
+
+// BlobURL returns the URL a blob is downloaded from. For registries that
+// redirect blob downloads this is the storage URL, which is usually presigned
+// and short-lived.
+//
+// Storage URLs are presigned for the method of the request, so they are
+// learned with a GET; asking for the first byte only keeps registries that
+// serve blobs themselves from sending all of it.
+func (r *registryApi) BlobURL(ctx context.Context, ref Refspec, digest string) (*url.URL, error) {
+	response, err := r.connector.Get(
+		connector.WithoutRedirects(r.requestContext(ctx, connector.OperationGetBlob, ref.Repository())),
+		r.endpointUrl(fmt.Sprintf("v2/%s/blobs/%s", ref.Repository(), digest)),
+		map[string]string{"Range": "bytes=0-0"},
+		cacheHintBlob(ref.Repository()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if location := connector.RedirectLocation(response); location != nil {
+		return location, nil
+	}
+
+	switch response.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		return connector.FinalURL(response), nil
+
+	case http.StatusForbidden, http.StatusUnauthorized:
+		return nil, newRegistryError(response, genericAuthorizationError)
+
+	case http.StatusNotFound:
+		return nil, newRegistryError(response, newNotFoundError(fmt.Sprintf("blob %s not found in repository %s", digest, ref.Repository())))
+
+	default:
+		return nil, newRegistryError(response, newInvalidStatusCodeError(response.StatusCode))
+	}
+}
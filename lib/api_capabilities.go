@@ -0,0 +1,281 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/kspeeder/docker-registry/lib/auth"
+	"github.com/kspeeder/docker-registry/lib/connector"
+)
+
+type Capability int
+
+const (
+	CapabilityUnknown Capability = iota
+	CapabilitySupported
+	CapabilityUnsupported
+)
+
+func (c Capability) String() string {
+	switch c {
+	case CapabilitySupported:
+		return "supported"
+	case CapabilityUnsupported:
+		return "unsupported"
+	default:
+		return "unknown"
+	}
+}
+
+// Capabilities describes optional registry features. Repository scoped
+// features stay unknown until probed against an existing image, and also if
+// the current credentials do not allow probing them. Delete and
+// ChunkMinLength are only probed if enabled with Config.SetProbeWrites, and
+// CrossRepositoryMount also needs a source image set with
+// Config.SetMountProbeSource.
+type Capabilities struct {
+	ApiVersion           string
+	Catalog              Capability
+	Referrers            Capability
+	Delete               Capability
+	CrossRepositoryMount Capability
+	RangeRequests        Capability
+	// ChunkMinLength is the OCI-Chunk-Min-Length announced for uploads; zero
+	// if the registry does not announce one.
+	ChunkMinLength int64
+
+	repositoryProbed bool
+}
+
+// A digest no registry will ever store; deleting it is a harmless probe.
+const probeDigest = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+
+// Capabilities probes the registry for optional features. If ref is non-nil,
+// it must name an existing image, which is used for the repository scoped
+// probes. Only reads are sent unless write probes are enabled. Results are
+// cached per host.
+func (r *registryApi) Capabilities(ctx context.Context, ref Refspec) (capabilities Capabilities, err error) {
+	host := r.cfg.registryUrl.Host
+
+	r.capabilitiesMutex.Lock()
+	cached, ok := r.capabilities[host]
+	r.capabilitiesMutex.Unlock()
+
+	if ok {
+		capabilities = *cached
+		if ref == nil || capabilities.repositoryProbed {
+			return
+		}
+	} else {
+		err = r.probeRegistry(ctx, &capabilities)
+		if err != nil {
+			return
+		}
+	}
+
+	if ref != nil {
+		err = r.probeRepository(ctx, ref, &capabilities)
+		if err != nil {
+			return
+		}
+		capabilities.repositoryProbed = true
+	}
+
+	r.capabilitiesMutex.Lock()
+	r.capabilities[host] = &capabilities
+	r.capabilitiesMutex.Unlock()
+
+	return
+}
+
+func (r *registryApi) probe(ctx context.Context, method string, url *url.URL, headers map[string]string, hint string) (*http.Response, error) {
+	response, err := r.connector.Request(ctx, method, url, headers, hint)
+	if err != nil {
+		return nil, err
+	}
+	response.Body.Close()
+
+	return response, nil
+}
+
+func (r *registryApi) probeRegistry(ctx context.Context, capabilities *Capabilities) (err error) {
+	ctx = r.requestContext(ctx, connector.OperationCapabilities, "")
+
+	catalogUrl := r.endpointUrl("v2/_catalog")
+	catalogUrl.RawQuery = "n=1"
+
+	// Registries send the API version with every response, so the catalog
+	// probe doubles as the version check.
+	response, err := r.probe(ctx, http.MethodGet, catalogUrl, nil, cacheHintRegistryList())
+	if err != nil {
+		return
+	}
+	capabilities.ApiVersion = response.Header.Get("Docker-Distribution-API-Version")
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		capabilities.Catalog = CapabilitySupported
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		capabilities.Catalog = CapabilityUnsupported
+	}
+
+	return
+}
+
+func (r *registryApi) probeRepository(ctx context.Context, ref Refspec, capabilities *Capabilities) (err error) {
+	repository := ref.Repository()
+
+	details, err := r.GetTagDetails(ctx, ref, 2)
+	if err != nil {
+		return
+	}
+	manifestDigest := details.ContentDigest()
+
+	ctx = r.requestContext(ctx, connector.OperationCapabilities, repository)
+
+	if manifestDigest != "" {
+		response, err := r.probe(ctx, http.MethodGet,
+			r.endpointUrl(fmt.Sprintf("v2/%s/referrers/%s", repository, manifestDigest)), nil, cacheHintTagDetails(repository))
+		if err != nil {
+			return err
+		}
+
+		switch response.StatusCode {
+		case http.StatusOK:
+			capabilities.Referrers = CapabilitySupported
+		case http.StatusNotFound, http.StatusMethodNotAllowed:
+			capabilities.Referrers = CapabilityUnsupported
+		}
+	}
+
+	if len(details.Layers()) == 0 {
+		return r.probeWrites(ctx, repository, capabilities)
+	}
+	layer := details.Layers()[0].ContentDigest()
+
+	response, err := r.probe(ctx, http.MethodGet,
+		r.endpointUrl(fmt.Sprintf("v2/%s/blobs/%s", repository, layer)), map[string]string{"Range": "bytes=0-0"}, cacheHintBlob(repository))
+	if err != nil {
+		return
+	}
+
+	switch response.StatusCode {
+	case http.StatusPartialContent:
+		capabilities.RangeRequests = CapabilitySupported
+	case http.StatusOK:
+		capabilities.RangeRequests = CapabilityUnsupported
+	}
+
+	return r.probeWrites(ctx, repository, capabilities)
+}
+
+func (r *registryApi) probeWrites(ctx context.Context, repository string, capabilities *Capabilities) (err error) {
+	if !r.cfg.probeWrites {
+		return
+	}
+
+	response, err := r.probe(ctx, http.MethodDelete,
+		r.endpointUrl(fmt.Sprintf("v2/%s/manifests/%s", repository, probeDigest)), nil, cacheHintDelete(repository))
+	if err != nil {
+		return
+	}
+
+	switch response.StatusCode {
+	case http.StatusMethodNotAllowed:
+		capabilities.Delete = CapabilityUnsupported
+	case http.StatusAccepted, http.StatusNotFound, http.StatusBadRequest:
+		capabilities.Delete = CapabilitySupported
+	}
+
+	err = r.probeUpload(ctx, repository, capabilities)
+	if err != nil {
+		return
+	}
+
+	return r.probeMount(ctx, repository, capabilities)
+}
+
+// probeUpload opens an upload session to read the announced chunk minimum and
+// cancels it right away.
+func (r *registryApi) probeUpload(ctx context.Context, repository string, capabilities *Capabilities) (err error) {
+	uploadUrl := r.endpointUrl(fmt.Sprintf("v2/%s/blobs/uploads/", repository))
+
+	response, err := r.probe(ctx, http.MethodPost, uploadUrl, nil, cacheHintPush(repository))
+	if err != nil || response.StatusCode != http.StatusAccepted {
+		return
+	}
+
+	if minLength, err := strconv.ParseInt(response.Header.Get("OCI-Chunk-Min-Length"), 10, 64); err == nil {
+		capabilities.ChunkMinLength = minLength
+	}
+
+	return r.cancelUpload(ctx, repository, uploadUrl, response)
+}
+
+// probeMount mounts the first layer of the configured source image into the
+// repository. Registries that ignore mount requests open an upload session
+// instead, which is cancelled right away. Layers the repository already has
+// tell nothing, so they leave mount support unknown.
+func (r *registryApi) probeMount(ctx context.Context, repository string, capabilities *Capabilities) (err error) {
+	source := r.cfg.mountProbeSource
+	if source == nil || source.Repository() == repository {
+		return
+	}
+
+	details, err := r.GetTagDetails(ctx, source, 2)
+	if err != nil || len(details.Layers()) == 0 {
+		return
+	}
+	layer := details.Layers()[0].ContentDigest()
+
+	response, err := r.probe(ctx, http.MethodHead,
+		r.endpointUrl(fmt.Sprintf("v2/%s/blobs/%s", repository, layer)), nil, cacheHintBlob(repository))
+	if err != nil || response.StatusCode != http.StatusNotFound {
+		return
+	}
+
+	uploadUrl := r.endpointUrl(fmt.Sprintf("v2/%s/blobs/uploads/", repository))
+	uploadUrl.RawQuery = url.Values{
+		"mount": {layer},
+		"from":  {source.Repository()},
+	}.Encode()
+
+	ctx = connector.WithScopes(ctx,
+		auth.RepositoryScope(source.Repository(), auth.ActionPull).String(),
+		auth.RepositoryScope(repository, auth.ActionPull, auth.ActionPush).String(),
+	)
+
+	response, err = r.probe(ctx, http.MethodPost, uploadUrl, nil, cacheHintPush(repository))
+	if err != nil {
+		return
+	}
+
+	switch response.StatusCode {
+	case http.StatusCreated:
+		capabilities.CrossRepositoryMount = CapabilitySupported
+	case http.StatusAccepted:
+		capabilities.CrossRepositoryMount = CapabilityUnsupported
+		return r.cancelUpload(ctx, repository, uploadUrl, response)
+	}
+
+	return
+}
+
+// cancelUpload cancels the upload session opened by response.
+func (r *registryApi) cancelUpload(ctx context.Context, repository string, uploadUrl *url.URL, response *http.Response) (err error) {
+	location := response.Header.Get("Location")
+	if location == "" {
+		return
+	}
+
+	sessionUrl, err := uploadUrl.Parse(location)
+	if err == nil && strings.Contains(sessionUrl.Path, "/blobs/uploads/") {
+		_, err = r.probe(ctx, http.MethodDelete, sessionUrl, nil, cacheHintPush(repository))
+	}
+
+	return
+}
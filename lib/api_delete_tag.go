@@ -3,34 +3,37 @@ package lib
 import (
 	"fmt"
 	"net/http"
+
+	"github.com/kspeeder/docker-registry/lib/connector"
 )
 
 func (r *registryApi) DeleteTag(ref Refspec) (err error) {
 	response, err := r.connector.Delete(
-		nil,
+		r.requestContext(nil, connector.OperationDeleteTag, ref.Repository()),
 		r.endpointUrl(fmt.Sprintf("/v2/%s/manifests/%s", ref.Repository(), ref.Reference())),
 		nil,
-		"",
+		cacheHintDelete(ref.Repository()),
 	)
 
 	if err != nil {
 		return
 	}
+	defer response.Body.Close()
 
 	switch response.StatusCode {
 	case http.StatusForbidden, http.StatusUnauthorized:
-		err = genericAuthorizationError
+		err = newRegistryError(response, genericAuthorizationError)
 
 	case http.StatusNotFound:
-		err = newNotFoundError(fmt.Sprintf("%v : no such repository or reference", ref))
+		err = newRegistryError(response, newNotFoundError(fmt.Sprintf("%v : no such repository or reference", ref)))
 
 	case http.StatusBadRequest:
-		err = newInvalidRequestError("invalid request --- make sure that your reference is a content digest")
+		err = newRegistryError(response, newInvalidRequestError("invalid request --- make sure that your reference is a content digest"))
 
 	case http.StatusAccepted:
 
 	default:
-		err = newInvalidStatusCodeError(response.StatusCode)
+		err = newRegistryError(response, newInvalidStatusCodeError(response.StatusCode))
 	}
 
 	return
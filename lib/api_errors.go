@@ -2,9 +2,6 @@ package lib
 
 import (
 	"fmt"
-	"io"
-	"net/http"
-	"strings"
 )
 
 type AutorizationError string
@@ -13,10 +10,15 @@ type MalformedResponseError string
 type InvalidStatusCodeError string
 type NotFoundError string
 type InvalidRequestError string
+type RangeNotSupportedError string
 
 var genericAuthorizationError AutorizationError = "authorization rejected by registry"
 var genericMalformedResponseError MalformedResponseError = "malformed response"
 
+// ErrRangeNotSupported is returned by RangeBlobs if the registry answers a
+// range request with the whole blob.
+var ErrRangeNotSupported RangeNotSupportedError = "got 200 response with range request"
+
 func (e AutorizationError) Error() string {
 	return string(e)
 }
@@ -41,6 +43,10 @@ func (e InvalidRequestError) Error() string {
 	return string(e)
 }
 
+func (e RangeNotSupportedError) Error() string {
+	return string(e)
+}
+
 func newInvalidStatusCodeError(code int) error {
 	return InvalidStatusCodeError(fmt.Sprintf("invalid API response status %d", code))
 }
@@ -52,21 +58,3 @@ func newNotFoundError(description string) error {
 func newInvalidRequestError(description string) error {
 	return InvalidRequestError(description)
 }
-
-func invalidStatusCodeErrorFromResponse(resp *http.Response) error {
-	if resp == nil {
-		return InvalidStatusCodeError("invalid API response status")
-	}
-
-	msg := fmt.Sprintf("invalid API response status %d", resp.StatusCode)
-
-	if resp.Body != nil {
-		if data, err := io.ReadAll(io.LimitReader(resp.Body, 2048)); err == nil {
-			if trimmed := strings.TrimSpace(string(data)); trimmed != "" {
-				msg = fmt.Sprintf("%s: %s", msg, trimmed)
-			}
-		}
-	}
-
-	return InvalidStatusCodeError(msg)
-}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"io"
 	"net/http"
+	"net/url"
 	"time"
 
+	"github.com/kspeeder/docker-registry/lib/auth"
 	"github.com/kspeeder/docker-registry/lib/connector"
 )
 
@@ -48,6 +50,14 @@ type RegistryApi interface {
 	GetStatistics() connector.Statistics
 	GetBlobs(ctx context.Context, ref Refspec, manifestVersion uint, digest string) (io.ReadCloser, error)
 	BlobInfo(ctx context.Context, ref Refspec, manifestVersion uint, digest string, extraHeaders map[string]string) (int64, time.Time, http.Header, error)
+	BlobURL(ctx context.Context, ref Refspec, digest string) (*url.URL, error)
 	RangeBlobs(ctx context.Context, ref Refspec, manifestVersion uint, digest string, start, end int64, extraHeaders map[string]string) (*http.Response, error)
 	Manifests(ctx context.Context, head bool, ref Refspec, manifestVersion uint, extraHeaders map[string]string) (*http.Response, error)
+	Capabilities(ctx context.Context, ref Refspec) (Capabilities, error)
+	CheckAccess(ctx context.Context, repository string, actions ...string) (AccessReport, error)
+	CanPull(ctx context.Context, repository string) (bool, error)
+	CanPush(ctx context.Context, repository string) (bool, error)
+	CanDelete(ctx context.Context, repository string) (bool, error)
+	SetCredentials(credentials RegistryCredentials)
+	SetCredentialProvider(provider auth.CredentialProvider)
 }
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+
+	"github.com/kspeeder/docker-registry/lib/connector"
 )
 
 func (r *registryApi) Manifests(ctx context.Context, head bool, ref Refspec, manifestVersion uint, extraHeaders map[string]string) (*http.Response, error) {
@@ -26,14 +28,14 @@ func (r *registryApi) Manifests(ctx context.Context, head bool, ref Refspec, man
 	var apiResponse *http.Response
 	if head {
 		apiResponse, err = r.connector.Head(
-			ctx,
+			r.requestContext(ctx, connector.OperationHeadManifest, ref.Repository()),
 			url,
 			headers,
 			cacheHintBlob(ref.Repository()),
 		)
 	} else {
 		apiResponse, err = r.connector.Get(
-			ctx,
+			r.requestContext(ctx, connector.OperationGetManifest, ref.Repository()),
 			url,
 			headers,
 			cacheHintBlob(ref.Repository()),
@@ -50,15 +52,15 @@ func (r *registryApi) Manifests(ctx context.Context, head bool, ref Refspec, man
 
 	switch apiResponse.StatusCode {
 	case http.StatusForbidden, http.StatusUnauthorized:
-		return nil, genericAuthorizationError
+		return nil, newRegistryError(apiResponse, genericAuthorizationError)
 
 	case http.StatusNotFound:
-		return nil, newNotFoundError(fmt.Sprintf("manifest %s not found in repository %s", ref.Reference(), ref.Repository()))
+		return nil, newRegistryError(apiResponse, newNotFoundError(fmt.Sprintf("manifest %s not found in repository %s", ref.Reference(), ref.Repository())))
 	case http.StatusOK:
 		respCopy := apiResponse
 		apiResponse = nil
 		return respCopy, nil
 	default:
-		return nil, newInvalidStatusCodeError(apiResponse.StatusCode)
+		return nil, newRegistryError(apiResponse, newInvalidStatusCodeError(apiResponse.StatusCode))
 	}
 }
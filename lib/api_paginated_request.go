@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/url"
+
+	"github.com/kspeeder/docker-registry/lib/connector"
 )
 
 type validatable interface {
@@ -23,10 +25,17 @@ type paginatedRequestContext interface {
 	createResponse(api *registryApi) paginatedRequestResponse
 	createJsonResponse() validatable
 	getHeaders() map[string]string
+	requestInfo() connector.RequestInfo
 }
 
 func (r *registryApi) executePaginatedRequest(ctx paginatedRequestContext, url *url.URL, initialRequest bool) (response *http.Response, close bool, err error) {
-	response, err = r.connector.Get(nil, url, ctx.getHeaders(), ctx.tokenCacheHint())
+	info := ctx.requestInfo()
+	response, err = r.connector.Get(
+		r.requestContext(nil, info.Operation, info.Repository),
+		url,
+		ctx.getHeaders(),
+		ctx.tokenCacheHint(),
+	)
 
 	if err != nil {
 		return
@@ -0,0 +1,121 @@
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RangeEmulatedHeader is set on RangeBlobs responses whose range was cut out
+// of a full response, because the server ignores range requests.
+const RangeEmulatedHeader = "X-Range-Emulated"
+
+type rangeBody struct {
+	io.Reader
+	io.Closer
+}
+
+// Hosts found to ignore range requests are checked again after this long.
+const rangeRecheckAfter = 10 * time.Minute
+
+// cutRange turns a full response into a partial one for the bytes from start
+// to end, exclusive; end -1 means the rest of the blob. Parts of the
+// Content-Range that cannot be told without a Content-Length are "*".
+func cutRange(response *http.Response, start, end int64) (*http.Response, error) {
+	size := response.ContentLength
+	if size >= 0 && start >= size {
+		response.Body.Close()
+		return nil, rangeNotSatisfiable(response, start)
+	}
+
+	body := bufio.NewReader(response.Body)
+	_, err := io.CopyN(io.Discard, body, start)
+	if err == nil && size < 0 {
+		// Without a size, only reading tells whether anything is left.
+		_, err = body.Peek(1)
+	}
+	if err != nil {
+		response.Body.Close()
+
+		if err == io.EOF {
+			return nil, rangeNotSatisfiable(response, start)
+		}
+		return nil, err
+	}
+
+	total, last := "*", "*"
+	if size >= 0 {
+		total = strconv.FormatInt(size, 10)
+	}
+
+	partial := *response
+	partial.StatusCode = http.StatusPartialContent
+	partial.Status = "206 Partial Content"
+	partial.Header = response.Header.Clone()
+	partial.Header.Set(RangeEmulatedHeader, "true")
+	partial.Header.Del("Content-Length")
+	partial.ContentLength = -1
+
+	var reader io.Reader = body
+	if size >= 0 {
+		lastByte := size - 1
+		if end > 0 && end <= size {
+			lastByte = end - 1
+		}
+		last = strconv.FormatInt(lastByte, 10)
+
+		partial.ContentLength = lastByte - start + 1
+		partial.Header.Set("Content-Length", strconv.FormatInt(partial.ContentLength, 10))
+		reader = io.LimitReader(body, partial.ContentLength)
+	} else if end > 0 {
+		// The blob may end before the range does, so neither the length nor
+		// the last byte is known.
+		reader = io.LimitReader(body, end-start)
+	}
+
+	partial.Body = rangeBody{
+		Reader: reader,
+		Closer: response.Body,
+	}
+	partial.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%s/%s", start, last, total))
+
+	return &partial, nil
+}
+
+// rangeNotSatisfiable is the error the registry would have answered a range
+// starting at or beyond the end of the blob with.
+func rangeNotSatisfiable(response *http.Response, start int64) error {
+	registryErr := &RegistryError{
+		StatusCode: http.StatusRequestedRangeNotSatisfiable,
+		legacy:     newInvalidRequestError(fmt.Sprintf("range start %d lies beyond the end of the blob", start)),
+	}
+
+	if response.Request != nil {
+		registryErr.Method = response.Request.Method
+		registryErr.URL = response.Request.URL.String()
+	}
+
+	return registryErr
+}
+
+func (r *registryApi) rangesUnsupported(host string) bool {
+	r.capabilitiesMutex.Lock()
+	defer r.capabilitiesMutex.Unlock()
+
+	until, ok := r.unsupportedRanges[host]
+	if ok && !time.Now().Before(until) {
+		delete(r.unsupportedRanges, host)
+		return false
+	}
+
+	return ok
+}
+
+func (r *registryApi) setRangesUnsupported(host string) {
+	r.capabilitiesMutex.Lock()
+	r.unsupportedRanges[host] = time.Now().Add(rangeRecheckAfter)
+	r.capabilitiesMutex.Unlock()
+}
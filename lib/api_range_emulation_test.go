@@ -0,0 +1,74 @@
+package lib
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func fullResponse(content string, contentLength int64) *http.Response {
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{},
+		Body:          io.NopCloser(strings.NewReader(content)),
+		ContentLength: contentLength,
+	}
+}
+
+func TestCutRange(t *testing.T) {
+	for _, test := range []struct {
+		contentLength int64
+		start, end    int64
+		data          string
+		contentRange  string
+	}{
+		{10, 2, 5, "234", "bytes 2-4/10"},
+		{10, 8, -1, "89", "bytes 8-9/10"},
+		{-1, 2, 5, "234", "bytes 2-*/*"},
+		{-1, 8, -1, "89", "bytes 8-*/*"},
+		{-1, 8, 15, "89", "bytes 8-*/*"},
+	} {
+		partial, err := cutRange(fullResponse("0123456789", test.contentLength), test.start, test.end)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, _ := io.ReadAll(partial.Body)
+		if string(data) != test.data || partial.Header.Get("Content-Range") != test.contentRange {
+			t.Fatalf("range %d-%d of %d bytes: got %q, Content-Range %q",
+				test.start, test.end, test.contentLength, data, partial.Header.Get("Content-Range"))
+		}
+
+		if test.contentLength < 0 && partial.ContentLength != -1 {
+			t.Fatalf("range %d-%d of unknown size: claimed %d bytes", test.start, test.end, partial.ContentLength)
+		}
+	}
+
+	for _, contentLength := range []int64{10, -1} {
+		for _, start := range []int64{10, 11} {
+			_, err := cutRange(fullResponse("0123456789", contentLength), start, -1)
+
+			var registryErr *RegistryError
+			if !errors.As(err, &registryErr) || registryErr.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+				t.Fatalf("start %d of %d bytes: expected a 416 error, got %v", start, contentLength, err)
+			}
+		}
+	}
+}
+
+func TestUnsupportedRangesExpire(t *testing.T) {
+	api := &registryApi{unsupportedRanges: make(map[string]time.Time)}
+
+	api.setRangesUnsupported("registry.example.com")
+	if !api.rangesUnsupported("registry.example.com") {
+		t.Fatal("the host should be marked")
+	}
+
+	api.unsupportedRanges["registry.example.com"] = time.Now().Add(-time.Second)
+	if api.rangesUnsupported("registry.example.com") {
+		t.Fatal("the mark should expire")
+	}
+}
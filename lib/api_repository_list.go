@@ -2,6 +2,8 @@ package lib
 
 import (
 	"net/http"
+
+	"github.com/kspeeder/docker-registry/lib/connector"
 )
 
 type repositoryListResponse struct {
@@ -46,20 +48,20 @@ func (r *repositoryListRequestContext) path() string {
 func (r *repositoryListRequestContext) validateApiResponse(response *http.Response, initialRequest bool) error {
 	switch response.StatusCode {
 	case http.StatusUnauthorized, http.StatusForbidden:
-		return genericAuthorizationError
+		return newRegistryError(response, genericAuthorizationError)
 
 	case http.StatusNotFound:
 		if initialRequest {
-			return NotImplementedByRemoteError("registry does not implement repository listings")
+			return newRegistryError(response, NotImplementedByRemoteError("registry does not implement repository listings"))
 		} else {
-			return newInvalidStatusCodeError(response.StatusCode)
+			return newRegistryError(response, newInvalidStatusCodeError(response.StatusCode))
 		}
 
 	case http.StatusOK:
 		return nil
 
 	default:
-		return newInvalidStatusCodeError(response.StatusCode)
+		return newRegistryError(response, newInvalidStatusCodeError(response.StatusCode))
 	}
 }
 
@@ -87,6 +89,12 @@ func (r *repositoryListRequestContext) getHeaders() map[string]string {
 	return nil
 }
 
+func (r *repositoryListRequestContext) requestInfo() connector.RequestInfo {
+	return connector.RequestInfo{
+		Operation: connector.OperationListRepositories,
+	}
+}
+
 func (r *registryApi) ListRepositories() RepositoryListResponse {
 	return r.paginatedRequest(new(repositoryListRequestContext)).(*repositoryListResponse)
 }
@@ -8,6 +8,8 @@ import (
 	"net/http"
 	"strconv"
 	"time"
+
+	"github.com/kspeeder/docker-registry/lib/connector"
 )
 
 func (r *registryApi) BlobInfo(ctx context.Context, ref Refspec, manifestVersion uint, digest string, extraHeaders map[string]string) (int64, time.Time, http.Header, error) {
@@ -28,7 +30,7 @@ func (r *registryApi) BlobInfo(ctx context.Context, ref Refspec, manifestVersion
 	}
 
 	resp, err := r.connector.Head(
-		ctx,
+		r.requestContext(ctx, connector.OperationHeadBlob, ref.Repository()),
 		url,
 		headers,
 		cacheHintBlob(ref.Repository()),
@@ -38,13 +40,6 @@ func (r *registryApi) BlobInfo(ctx context.Context, ref Refspec, manifestVersion
 	}
 	defer resp.Body.Close()
 
-	/* if resp != nil {
-		b, err := httputil.DumpResponse(resp, false)
-		if err == nil {
-			fmt.Println("blobs=\n", string(b)) comment
-		}
-	} */
-
 	if resp.StatusCode != http.StatusOK {
 		return 0, time.Time{}, nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
@@ -79,11 +74,17 @@ func (r *registryApi) RangeBlobs(ctx context.Context, ref Refspec, manifestVersi
 	if err != nil {
 		return nil, err
 	}
-	// Set Range header in format "bytes=start-end"
-	var useRange bool
-	if end > 0 {
-		headers["Range"] = fmt.Sprintf("bytes=%d-%d", start, end-1)
-		useRange = true
+	// Set Range header in format "bytes=start-end", or "bytes=start-" for
+	// the rest of the blob. Hosts known to ignore ranges get none if ranges
+	// are emulated.
+	useRange := end > 0 || start > 0
+	emulateRange := r.cfg.emulateRanges && r.rangesUnsupported(url.Host)
+	if useRange && !emulateRange {
+		if end > 0 {
+			headers["Range"] = fmt.Sprintf("bytes=%d-%d", start, end-1)
+		} else {
+			headers["Range"] = fmt.Sprintf("bytes=%d-", start)
+		}
 	}
 	if len(extraHeaders) > 0 {
 		for k, v := range extraHeaders {
@@ -91,8 +92,12 @@ func (r *registryApi) RangeBlobs(ctx context.Context, ref Refspec, manifestVersi
 		}
 	}
 
+	if response, ok := r.rangeFromStorage(ctx, ref.Repository(), digest, headers, useRange && !emulateRange); ok {
+		return response, nil
+	}
+
 	apiResponse, err := r.connector.Get(
-		ctx,
+		r.requestContext(ctx, connector.OperationRangeBlob, ref.Repository()),
 		url,
 		headers,
 		cacheHintBlob(ref.Repository()),
@@ -106,40 +111,36 @@ func (r *registryApi) RangeBlobs(ctx context.Context, ref Refspec, manifestVersi
 		}
 	}()
 
-	/* if apiResponse != nil {
-		b, err := httputil.DumpResponse(apiResponse, false)
-		if err == nil {
-			fmt.Println("blobs=\n", string(b)) comment
-		}
-	} */
-
 	switch apiResponse.StatusCode {
 	case http.StatusForbidden, http.StatusUnauthorized:
-		if apiResponse.Close {
-			apiResponse.Body.Close()
-		}
-		return nil, genericAuthorizationError
+		return nil, newRegistryError(apiResponse, genericAuthorizationError)
 
 	case http.StatusNotFound:
-		if apiResponse.Close {
-			apiResponse.Body.Close()
-		}
-		return nil, newNotFoundError(fmt.Sprintf("blob %s not found in repository %s", digest, ref.Repository()))
+		return nil, newRegistryError(apiResponse, newNotFoundError(fmt.Sprintf("blob %s not found in repository %s", digest, ref.Repository())))
 	case http.StatusOK:
 		// Got 200 response with range request, should be 206
+		if useRange && r.cfg.emulateRanges {
+			r.setRangesUnsupported(url.Host)
+
+			respCopy := apiResponse
+			apiResponse = nil
+			return cutRange(respCopy, start, end)
+		}
 		if useRange {
-			apiResponse.Body.Close()
-			return nil, errors.New("got 200 response with range request")
+			return nil, ErrRangeNotSupported
 		}
 		respCopy := apiResponse
 		apiResponse = nil
 		return respCopy, nil
 	case http.StatusPartialContent:
+		if finalUrl := connector.FinalURL(apiResponse); finalUrl.Host != url.Host {
+			r.blobRedirects.Set(ref.Repository(), digest, finalUrl)
+		}
 		respCopy := apiResponse
 		apiResponse = nil
 		return respCopy, nil
 	default:
-		return nil, invalidStatusCodeErrorFromResponse(apiResponse)
+		return nil, newRegistryError(apiResponse, newInvalidStatusCodeError(apiResponse.StatusCode))
 	}
 }
 
@@ -156,7 +157,7 @@ func (r *registryApi) GetBlobs(ctx context.Context, ref Refspec, manifestVersion
 	}
 
 	apiResponse, err := r.connector.Get(
-		ctx,
+		r.requestContext(ctx, connector.OperationGetBlob, ref.Repository()),
 		url,
 		headers,
 		cacheHintBlob(ref.Repository()),
@@ -165,28 +166,58 @@ func (r *registryApi) GetBlobs(ctx context.Context, ref Refspec, manifestVersion
 		return nil, err
 	}
 
-	/* if apiResponse != nil {
-		b, err := httputil.DumpResponse(apiResponse, false)
-		if err == nil {
-			fmt.Println("blobs=\n", string(b))  comment
-		}
-	} */
+	if apiResponse.StatusCode == http.StatusOK {
+		return apiResponse.Body, nil
+	}
+	defer apiResponse.Body.Close()
 
 	switch apiResponse.StatusCode {
 	case http.StatusForbidden, http.StatusUnauthorized:
-		apiResponse.Body.Close()
-		return nil, genericAuthorizationError
+		return nil, newRegistryError(apiResponse, genericAuthorizationError)
 
 	case http.StatusNotFound:
-		apiResponse.Body.Close()
-		return nil, newNotFoundError(fmt.Sprintf("blob %s not found in repository %s", digest, ref.Repository()))
-
-	case http.StatusOK:
-		return apiResponse.Body, nil
+		return nil, newRegistryError(apiResponse, newNotFoundError(fmt.Sprintf("blob %s not found in repository %s", digest, ref.Repository())))
 
 	default:
-		err := invalidStatusCodeErrorFromResponse(apiResponse)
-		apiResponse.Body.Close()
-		return nil, err
+		return nil, newRegistryError(apiResponse, newInvalidStatusCodeError(apiResponse.StatusCode))
+	}
+}
+
+// rangeFromStorage sends a range request straight to the cached storage URL
+// of the blob. Only the Range and Accept headers are sent, as the storage
+// host is not the registry. If it answers with anything but the range, the
+// URL is dropped; in any case of failure the caller goes through the registry
+// again.
+func (r *registryApi) rangeFromStorage(ctx context.Context, repository, digest string, headers map[string]string, useRange bool) (*http.Response, bool) {
+	storageUrl := r.blobRedirects.Get(repository, digest)
+	if storageUrl == nil || !useRange {
+		return nil, false
+	}
+
+	storageHeaders := make(map[string]string)
+	for _, header := range []string{"Range", "Accept"} {
+		if value, ok := headers[header]; ok {
+			storageHeaders[header] = value
+		}
 	}
+
+	response, err := r.connector.Get(
+		connector.WithoutCredentials(r.requestContext(ctx, connector.OperationRangeBlob, repository)),
+		storageUrl,
+		storageHeaders,
+		"",
+	)
+	if err != nil {
+		// Cancellation and network errors say nothing about the URL.
+		return nil, false
+	}
+
+	if response.StatusCode == http.StatusPartialContent {
+		return response, true
+	}
+
+	response.Body.Close()
+	r.blobRedirects.Remove(repository, digest)
+
+	return nil, false
 }
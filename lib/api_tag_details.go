@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/kspeeder/docker-registry/lib/connector"
 	"github.com/opencontainers/go-digest"
 )
 
@@ -64,7 +65,7 @@ func (r *registryApi) GetTagDetails(ctx context.Context, ref Refspec, manifestVe
 	}
 
 	apiResponse, err := r.connector.Get(
-		ctx,
+		r.requestContext(ctx, connector.OperationGetManifest, ref.Repository()),
 		url,
 		headers,
 		cacheHintTagDetails(ref.Repository()),
@@ -77,15 +78,15 @@ func (r *registryApi) GetTagDetails(ctx context.Context, ref Refspec, manifestVe
 
 	switch apiResponse.StatusCode {
 	case http.StatusForbidden, http.StatusUnauthorized:
-		err = genericAuthorizationError
+		err = newRegistryError(apiResponse, genericAuthorizationError)
 
 	case http.StatusNotFound:
-		err = newNotFoundError(fmt.Sprintf("%v : no such repository or reference", ref))
+		err = newRegistryError(apiResponse, newNotFoundError(fmt.Sprintf("%v : no such repository or reference", ref)))
 
 	case http.StatusOK:
 
 	default:
-		err = invalidStatusCodeErrorFromResponse(apiResponse)
+		err = newRegistryError(apiResponse, newInvalidStatusCodeError(apiResponse.StatusCode))
 	}
 
 	if err != nil {
@@ -157,7 +158,7 @@ func (r *registryApi) ManifestDigest(ref Refspec, manifestVersion uint, reposito
 	if err != nil {
 		return "", err
 	}
-	resp, err := r.connector.Head(context.TODO(), url, headers, "")
+	resp, err := r.connector.Head(r.requestContext(context.TODO(), connector.OperationHeadManifest, repository), url, headers, cacheHintTagDetails(repository))
 	if resp != nil {
 		defer resp.Body.Close()
 	}
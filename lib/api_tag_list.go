@@ -2,8 +2,9 @@ package lib
 
 import (
 	"fmt"
-	"log"
 	"net/http"
+
+	"github.com/kspeeder/docker-registry/lib/connector"
 )
 
 type tagListResponse struct {
@@ -49,24 +50,22 @@ func (r *tagListRequestContext) path() string {
 }
 
 func (r *tagListRequestContext) validateApiResponse(response *http.Response, initialRequest bool) error {
-	log.Printf("api response: %#v", response)
-
 	switch response.StatusCode {
 	case http.StatusUnauthorized, http.StatusForbidden:
-		return genericAuthorizationError
+		return newRegistryError(response, genericAuthorizationError)
 
 	case http.StatusNotFound:
 		if initialRequest {
-			return newNotFoundError(fmt.Sprintf("%s: no such repository", r.repositoryName))
+			return newRegistryError(response, newNotFoundError(fmt.Sprintf("%s: no such repository", r.repositoryName)))
 		} else {
-			return newInvalidStatusCodeError(response.StatusCode)
+			return newRegistryError(response, newInvalidStatusCodeError(response.StatusCode))
 		}
 
 	case http.StatusOK:
 		return nil
 
 	default:
-		return newInvalidStatusCodeError(response.StatusCode)
+		return newRegistryError(response, newInvalidStatusCodeError(response.StatusCode))
 	}
 }
 
@@ -94,6 +93,13 @@ func (r *tagListRequestContext) getHeaders() map[string]string {
 	return nil
 }
 
+func (r *tagListRequestContext) requestInfo() connector.RequestInfo {
+	return connector.RequestInfo{
+		Operation:  connector.OperationListTags,
+		Repository: r.repositoryName,
+	}
+}
+
 func (r *registryApi) ListTags(repositoryName string) TagListResponse {
 	ctx := tagListRequestContext{
 		repositoryName: repositoryName,
@@ -0,0 +1,45 @@
+package auth
+
+import "context"
+
+// AnonymousFallbackHandler is called when a token was requested anonymously
+// because the auth server rejected the credentials of the account.
+type AnonymousFallbackHandler func(account string, scopes []string, rejection error)
+
+// fetchAnonymousFallback retries a rejected token request for pull-only
+// scopes without credentials. The rejection is returned if that fails too.
+func (a *authenticator) fetchAnonymousFallback(ctx context.Context, c *Challenge, credentials Credentials, rejection error) (response authResponse, err error) {
+	if a.anonymousFallback == nil || credentials.IsBlank() || !a.rejected(rejection) || !pullOnly(c.scope) {
+		return response, rejection
+	}
+
+	response, err = a.fetchTokenJWT(ctx, c, Credentials{})
+	if err != nil {
+		return response, rejection
+	}
+
+	a.anonymousFallback(credentials.Username, c.scope, rejection)
+
+	return
+}
+
+func pullOnly(scopes []string) bool {
+	if len(scopes) == 0 {
+		return false
+	}
+
+	for _, value := range scopes {
+		scope, err := ParseScope(value)
+		if err != nil || scope.Type != "repository" {
+			return false
+		}
+
+		for _, action := range scope.Actions {
+			if action != ActionPull {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RawChallenge is a single challenge of a WWW-Authenticate header as defined
+// by RFC 7235. Parameter names are lower case.
+type RawChallenge struct {
+	Scheme  string
+	Token68 string
+	Params  map[string]string
+}
+
+type authParamParser struct {
+	input    string
+	position int
+}
+
+// ParseAuthenticate parses the challenges of the given WWW-Authenticate
+// header values.
+func ParseAuthenticate(headers []string) (challenges []RawChallenge, err error) {
+	for _, header := range headers {
+		parser := authParamParser{input: header}
+
+		var parsed []RawChallenge
+		parsed, err = parser.challenges()
+		if err != nil {
+			return nil, err
+		}
+
+		challenges = append(challenges, parsed...)
+	}
+
+	return
+}
+
+func (p *authParamParser) challenges() (challenges []RawChallenge, err error) {
+	for {
+		p.skip(" \t,")
+		if p.eof() {
+			return
+		}
+
+		scheme := p.token()
+		if scheme == "" {
+			return nil, p.errorf("expected auth scheme")
+		}
+
+		challenge := RawChallenge{
+			Scheme: scheme,
+			Params: make(map[string]string),
+		}
+		p.skip(" \t")
+
+		if token68, ok := p.token68(); ok {
+			challenge.Token68 = token68
+		} else if err = p.params(challenge.Params); err != nil {
+			return nil, err
+		}
+
+		challenges = append(challenges, challenge)
+	}
+}
+
+// params parses auth-params up to the end of input or the start of the next
+// challenge.
+func (p *authParamParser) params(params map[string]string) error {
+	for {
+		p.skip(" \t")
+		start := p.position
+
+		name := p.token()
+		if name == "" {
+			if !p.eof() && p.peek() != ',' {
+				return p.errorf("expected auth-param")
+			}
+			return nil
+		}
+
+		p.skip(" \t")
+		if p.peek() != '=' {
+			// A token without value starts the next challenge.
+			p.position = start
+			return nil
+		}
+		p.position++
+		p.skip(" \t")
+
+		var value string
+		if p.peek() == '"' {
+			var err error
+			if value, err = p.quotedString(); err != nil {
+				return err
+			}
+		} else if value = p.token(); value == "" {
+			return p.errorf("expected value for %s", name)
+		}
+
+		params[strings.ToLower(name)] = value
+
+		p.skip(" \t")
+		switch {
+		case p.eof():
+			return nil
+		case p.peek() == ',':
+			p.position++
+		default:
+			return p.errorf("expected comma")
+		}
+	}
+}
+
+// token68 consumes a token68 if it is the only credential of the challenge.
+func (p *authParamParser) token68() (value string, ok bool) {
+	start := p.position
+
+	for !p.eof() && isToken68Char(p.peek()) {
+		p.position++
+	}
+	for !p.eof() && p.peek() == '=' {
+		p.position++
+	}
+	value = p.input[start:p.position]
+
+	p.skip(" \t")
+	if value != "" && (p.eof() || p.peek() == ',') {
+		return value, true
+	}
+
+	p.position = start
+	return "", false
+}
+
+func (p *authParamParser) token() string {
+	start := p.position
+	for !p.eof() && isTokenChar(p.peek()) {
+		p.position++
+	}
+
+	return p.input[start:p.position]
+}
+
+func (p *authParamParser) quotedString() (string, error) {
+	var value strings.Builder
+
+	for p.position++; !p.eof(); p.position++ {
+		switch c := p.peek(); c {
+		case '"':
+			p.position++
+			return value.String(), nil
+		case '\\':
+			p.position++
+			if p.eof() {
+				return "", p.errorf("unterminated quoted string")
+			}
+			value.WriteByte(p.peek())
+		default:
+			value.WriteByte(c)
+		}
+	}
+
+	return "", p.errorf("unterminated quoted string")
+}
+
+func (p *authParamParser) skip(chars string) {
+	for !p.eof() && strings.IndexByte(chars, p.peek()) >= 0 {
+		p.position++
+	}
+}
+
+func (p *authParamParser) peek() byte {
+	return p.input[p.position]
+}
+
+func (p *authParamParser) eof() bool {
+	return p.position >= len(p.input)
+}
+
+func (p *authParamParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("malformed challenge header '%s' at offset %d: %s",
+		p.input, p.position, fmt.Sprintf(format, args...))
+}
+
+func isTokenChar(c byte) bool {
+	return isAlphaNumeric(c) || strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
+func isToken68Char(c byte) bool {
+	return isAlphaNumeric(c) || strings.IndexByte("-._~+/", c) >= 0
+}
+
+func isAlphaNumeric(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
@@ -4,11 +4,41 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"time"
 )
 
+// Lifetime assumed for tokens that do not specify expires_in, as mandated by
+// the token authentication specification.
+const defaultTokenLifetime = 60 * time.Second
+
 // authResponse stores an JWT token or OAuth2 Access token.
 type authResponse struct {
-	Token string `json:"token"`
+	Token        string    `json:"token"`
+	ExpiresIn    int       `json:"expires_in"`
+	IssuedAt     string    `json:"issued_at"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresAt    time.Time `json:"-"`
+}
+
+func (r *authResponse) computeExpiry(received time.Time) {
+	issued := received
+
+	// Never trust an issue date from the future; clock skew would otherwise
+	// extend the lifetime of the token.
+	if parsed, err := time.Parse(time.RFC3339, r.IssuedAt); err == nil && parsed.Before(received) {
+		issued = parsed
+	}
+
+	lifetime := time.Duration(r.ExpiresIn) * time.Second
+	if lifetime <= 0 {
+		lifetime = defaultTokenLifetime
+	}
+
+	r.ExpiresAt = issued.Add(lifetime)
+}
+
+func (r *authResponse) expired() bool {
+	return !time.Now().Before(r.ExpiresAt)
 }
 
 func decodeAuthResponse(serverResponse io.Reader) (response authResponse, err error) {
@@ -20,13 +50,18 @@ func decodeAuthResponse(serverResponse io.Reader) (response authResponse, err er
 		err = errors.New("malformed auth server response")
 	}
 
+	response.computeExpiry(time.Now())
+
 	return
 }
 
 // auth2Response stores an OAuth2 response.
 // https://docs.docker.com/registry/spec/auth/oauth/
 type auth2Response struct {
-	AccessToken string `json:"access_token"`
+	AccessToken  string `json:"access_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	IssuedAt     string `json:"issued_at"`
+	RefreshToken string `json:"refresh_token"`
 }
 
 func decodeAuth2Response(serverResponse io.Reader) (response authResponse, err error) {
@@ -35,10 +70,15 @@ func decodeAuth2Response(serverResponse io.Reader) (response authResponse, err e
 	var oauth2Response auth2Response
 	err = decoder.Decode(&oauth2Response)
 	response.Token = oauth2Response.AccessToken
+	response.ExpiresIn = oauth2Response.ExpiresIn
+	response.IssuedAt = oauth2Response.IssuedAt
+	response.RefreshToken = oauth2Response.RefreshToken
 
 	if err == nil && response.Token == "" {
 		err = errors.New("malformed auth server response")
 	}
 
+	response.computeExpiry(time.Now())
+
 	return
 }
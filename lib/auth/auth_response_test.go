@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeAuthResponseExpiry(t *testing.T) {
+	response, err := decodeAuthResponse(strings.NewReader(`{"token":"abc"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lifetime := time.Until(response.ExpiresAt); lifetime <= 55*time.Second || lifetime > defaultTokenLifetime {
+		t.Fatalf("expected the default lifetime, got %v", lifetime)
+	}
+
+	issued := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	response, err = decodeAuth2Response(strings.NewReader(`{"access_token":"abc","expires_in":90,"issued_at":"` + issued + `"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lifetime := time.Until(response.ExpiresAt); lifetime <= 25*time.Second || lifetime > 30*time.Second {
+		t.Fatalf("expected expiry relative to issued_at, got %v", lifetime)
+	}
+
+	response.ExpiresAt = time.Now().Add(-time.Second)
+	cache := newTokenCache()
+	challenge := &Challenge{realm: &url.URL{Scheme: "https", Host: "auth.example.com", Path: "/token"}, service: "example"}
+	cache.Set(newCacheKey(challenge, ""), response)
+
+	if _, cached := cache.Get(newCacheKey(challenge, "")); cached {
+		t.Fatal("expired tokens should be evicted")
+	}
+}
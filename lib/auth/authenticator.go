@@ -1,91 +1,241 @@
 package auth
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+
+	"github.com/kspeeder/docker-registry/lib/internal/singleflight"
 )
 
 type authenticator struct {
-	httpClient    *http.Client
-	credentials   RegistryCredentials
-	cache         *tokenCache
-	fastChannel   bool
-	tokenProvider FastChannelTokenProvider
+	httpClient       *http.Client
+	credentials      CredentialProvider
+	cache            tokenStore
+	fastChannel      bool
+	tokenProvider    FastChannelTokenProvider
+	oauth2           OAuth2Options
+	refreshTokens    map[string]string
+	fastChannelCache *fastChannelCache
+	fetches          singleflight.Group[authResponse]
+	// resolved remembers the credentials last resolved per target, so that
+	// providers are only asked again on a token cache miss.
+	resolved map[credentialTarget]Credentials
+	// anonymousFallback is set if rejected credentials may be replaced by
+	// anonymous pull tokens.
+	anonymousFallback AnonymousFallbackHandler
+	// generation is bumped on invalidation, so tokens fetched before are not
+	// cached again.
+	generation uint64
+	mutex      sync.Mutex
 }
 
-func (a *authenticator) Authenticate(c *Challenge, ignoreCached bool) (t Token, err error) {
-	if !ignoreCached {
-		value := a.cache.Get(c)
+// authServerError is returned if the auth server rejects a token request.
+type authServerError struct {
+	flow       string
+	statusCode int
+}
+
+func (e *authServerError) Error() string {
+	return fmt.Sprintf("%sauthentication against auth server failed with code %d", e.flow, e.statusCode)
+}
+
+// AuthServerStatusCode returns the status code the auth server rejected a
+// token request with.
+func AuthServerStatusCode(err error) (statusCode int, ok bool) {
+	var serverErr *authServerError
+	if errors.As(err, &serverErr) {
+		return serverErr.statusCode, true
+	}
+
+	return
+}
+
+func (a *authenticator) Authenticate(ctx context.Context, c *Challenge, ignoreCached bool) (t Token, err error) {
+	target := credentialTargetFromContext(ctx, c)
 
-		if value != "" {
-			t = newToken(value, false)
+	a.mutex.Lock()
+	generation := a.generation
+	credentials, known := a.resolved[target]
+	a.mutex.Unlock()
+
+	if known && !ignoreCached {
+		if cached, ok := a.cache.Get(newCacheKey(c, a.cache.accountKey(credentials))); ok {
+			t = newToken(cached, false)
 			return
 		}
 	}
 
-	// Try OAuth2 authentication first, and then legacy JWT tokens.
-	var decodedResponse authResponse
-	if a.fastChannel {
-		decodedResponse, err = a.fetchFastChannelToken(c)
-	} else if refreshToken := a.credentials.IdentityToken(); refreshToken != "" {
-		decodedResponse, err = a.fetchTokenOAuth2(c, refreshToken)
-	} else {
-		decodedResponse, err = a.fetchTokenJWT(c)
+	credentials, err = a.resolveCredentials(ctx, target)
+	if err != nil {
+		return
+	}
+
+	key := newCacheKey(c, a.cache.accountKey(credentials))
+
+	if !ignoreCached {
+		if cached, ok := a.cache.Get(key); ok {
+			t = newToken(cached, false)
+			return
+		}
 	}
+
+	// Concurrent requests hitting the same challenge share one token fetch.
+	flightKey := strings.Join([]string{key.realm, key.service, key.scopes, key.account, strconv.FormatUint(generation, 10)}, "\x00")
+
+	decodedResponse, err, _ := a.fetches.Do(ctx, flightKey, func(ctx context.Context) (response authResponse, err error) {
+		response, err = a.fetchToken(ctx, c, credentials, ignoreCached)
+		if err != nil {
+			response, err = a.fetchAnonymousFallback(ctx, c, credentials, err)
+		}
+		if err != nil {
+			return
+		}
+
+		a.mutex.Lock()
+		if generation == a.generation {
+			a.cache.Set(key, response)
+		}
+		a.mutex.Unlock()
+
+		return
+	})
 	if err != nil {
 		return
 	}
 
-	a.cache.Set(c, decodedResponse)
-	t = newToken(decodedResponse.Token, true)
+	t = newToken(decodedResponse, true)
 
 	return
 }
 
-func (a *authenticator) fetchFastChannelToken(challenge *Challenge) (decodedResponse authResponse, err error) {
-	realm := challenge.Realm()
-	service := challenge.Service()
-	scope := challenge.Scope()
+func (a *authenticator) Invalidate() {
+	a.mutex.Lock()
+	a.generation++
+	a.refreshTokens = make(map[string]string)
+	a.resolved = make(map[credentialTarget]Credentials)
+	a.cache.Invalidate()
+	a.fastChannelCache.Invalidate()
+	a.mutex.Unlock()
+}
 
-	if a.tokenProvider == nil {
-		err = errors.New("fast channel token provider is not configured")
-		return
+func (a *authenticator) resolveCredentials(ctx context.Context, target credentialTarget) (credentials Credentials, err error) {
+	if a.credentials != nil {
+		credentials, _, err = a.credentials.Credentials(ctx, target.registry, target.repository)
+		if err != nil {
+			return
+		}
 	}
 
-	mirrorToken, err := a.tokenProvider.RequestMirrorToken(realm.Host, service, scope)
-	if err != nil {
-		return
+	a.mutex.Lock()
+	a.resolved[target] = credentials
+	a.mutex.Unlock()
+
+	return
+}
+
+func (a *authenticator) fetchToken(ctx context.Context, c *Challenge, credentials Credentials, ignoreCached bool) (decodedResponse authResponse, err error) {
+	// Try the fast channel first, then OAuth2 authentication, and then
+	// legacy JWT tokens. Fast channel failures, including scopes it does not
+	// serve, fall back to the auth server.
+	if a.fastChannel && a.tokenProvider != nil {
+		if decodedResponse, err = a.fetchFastChannelToken(ctx, c, ignoreCached); err == nil {
+			return
+		}
 	}
 
-	tokenData := map[string]string{
-		"token": mirrorToken,
+	if refreshToken := a.identityToken(c, credentials); refreshToken != "" {
+		decodedResponse, err = a.fetchTokenOAuth2(ctx, c, credentials, url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {refreshToken},
+		})
+
+		// A revoked refresh token is replaced by logging in again, if we
+		// have the password.
+		if !a.rejected(err) || credentials.Password == "" {
+			return
+		}
 	}
 
-	jsonData, err := json.Marshal(tokenData)
-	if err != nil {
+	if a.oauth2.OfflineAccess && credentials.Username != "" && credentials.Password != "" {
+		decodedResponse, err = a.fetchTokenOAuth2(ctx, c, credentials, url.Values{
+			"grant_type":  {"password"},
+			"username":    {credentials.Username},
+			"password":    {credentials.Password},
+			"access_type": {"offline"},
+		})
+
+		// Auth servers that do not implement OAuth2 reject the POST.
+		var serverErr *authServerError
+		if !errors.As(err, &serverErr) ||
+			serverErr.statusCode != http.StatusNotFound && serverErr.statusCode != http.StatusMethodNotAllowed {
+			return
+		}
+	}
+
+	return a.fetchTokenJWT(ctx, c, credentials)
+}
+
+func (a *authenticator) rejected(err error) bool {
+	var serverErr *authServerError
+	return errors.As(err, &serverErr) &&
+		(serverErr.statusCode == http.StatusBadRequest || serverErr.statusCode == http.StatusUnauthorized)
+}
+
+// refreshTokenKey identifies the account at the auth server of c, like the
+// token cache keys do.
+func (a *authenticator) refreshTokenKey(c *Challenge, credentials Credentials) string {
+	return c.realm.String() + "\x00" + c.service + "\x00" + a.cache.accountKey(credentials)
+}
+
+// identityToken returns the latest refresh token issued by the auth server
+// for the account, or the one from the credentials.
+func (a *authenticator) identityToken(c *Challenge, credentials Credentials) string {
+	key := a.refreshTokenKey(c, credentials)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if refreshToken, ok := a.refreshTokens[key]; ok {
+		return refreshToken
+	}
+
+	return credentials.IdentityToken
+}
+
+func (a *authenticator) captureRefreshToken(c *Challenge, credentials Credentials, refreshToken string) {
+	if refreshToken == "" || credentials.IsBlank() {
 		return
 	}
 
-	decodedResponse, err = decodeAuthResponse(bytes.NewReader(jsonData))
-	return
+	key := a.refreshTokenKey(c, credentials)
+
+	a.mutex.Lock()
+	previous, known := a.refreshTokens[key]
+	changed := refreshToken != credentials.IdentityToken && (!known || refreshToken != previous)
+	a.refreshTokens[key] = refreshToken
+	a.mutex.Unlock()
+
+	// The handler files refresh tokens under the user name.
+	if changed && credentials.Username != "" && a.oauth2.RefreshTokenHandler != nil {
+		a.oauth2.RefreshTokenHandler(credentials.Username, refreshToken)
+	}
 }
 
-func (a *authenticator) fetchTokenJWT(c *Challenge) (decodedResponse authResponse, err error) {
+func (a *authenticator) fetchTokenJWT(ctx context.Context, c *Challenge, credentials Credentials) (decodedResponse authResponse, err error) {
 	requestUrl := c.buildRequestUrl()
-	authRequest, err := http.NewRequest("GET", requestUrl.String(), strings.NewReader(""))
+	authRequest, err := http.NewRequestWithContext(ctx, "GET", requestUrl.String(), strings.NewReader(""))
 	if err != nil {
 		return
 	}
 
-	username := a.credentials.User()
-	password := a.credentials.Password()
-	if username != "" || password != "" {
-		authRequest.SetBasicAuth(username, password)
+	if credentials.Username != "" || credentials.Password != "" {
+		authRequest.SetBasicAuth(credentials.Username, credentials.Password)
 	}
 
 	authResponse, err := a.httpClient.Do(authRequest)
@@ -98,42 +248,73 @@ func (a *authenticator) fetchTokenJWT(c *Challenge) (decodedResponse authRespons
 	}
 
 	if authResponse.StatusCode != http.StatusOK {
-		err = errors.New(fmt.Sprintf("authentication against auth server failed with code %d", authResponse.StatusCode))
+		err = &authServerError{statusCode: authResponse.StatusCode}
 		return
 	}
 
 	decodedResponse, err = decodeAuthResponse(authResponse.Body)
+	if err == nil {
+		a.captureRefreshToken(c, credentials, decodedResponse.RefreshToken)
+	}
+
 	return
 }
 
-func (a *authenticator) fetchTokenOAuth2(c *Challenge, refreshToken string) (decodedResponse authResponse, err error) {
-	authResponse, err := a.httpClient.PostForm(c.realm.String(), url.Values{
-		"grant_type":    {"refresh_token"},
-		"service":       {c.service},
-		"client_id":     {"docker-ls"},
-		"scope":         {strings.Join(c.scope, " ")},
-		"refresh_token": {refreshToken},
-	})
+func (a *authenticator) fetchTokenOAuth2(ctx context.Context, c *Challenge, credentials Credentials, form url.Values) (decodedResponse authResponse, err error) {
+	form.Set("client_id", a.oauth2.clientId())
+	form.Set("scope", strings.Join(c.scope, " "))
+	if c.service != "" {
+		form.Set("service", c.service)
+	}
+
+	authRequest, err := http.NewRequestWithContext(ctx, "POST", c.realm.String(), strings.NewReader(form.Encode()))
 	if err != nil {
 		return
 	}
+	authRequest.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	authResponse, err := a.httpClient.Do(authRequest)
+	if err != nil {
+		return
+	}
+	defer authResponse.Body.Close()
 
 	if authResponse.StatusCode != http.StatusOK {
-		err = errors.New(fmt.Sprintf("OAuth2 authentication against auth server failed with code %d", authResponse.StatusCode))
+		err = &authServerError{flow: "OAuth2 ", statusCode: authResponse.StatusCode}
 		return
 	}
 
 	decodedResponse, err = decodeAuth2Response(authResponse.Body)
+	if err == nil {
+		a.captureRefreshToken(c, credentials, decodedResponse.RefreshToken)
+	}
+
 	return
 }
 
-func NewAuthenticator(client *http.Client, credentials RegistryCredentials, fastChannel bool, tokenProvider FastChannelTokenProvider) Authenticator {
+func NewAuthenticator(
+	client *http.Client,
+	credentials CredentialProvider,
+	fastChannel bool,
+	tokenProvider FastChannelTokenProvider,
+	options AuthenticatorOptions,
+) Authenticator {
 	auth := &authenticator{
-		httpClient:    client,
-		credentials:   credentials,
-		cache:         newTokenCache(),
-		fastChannel:   fastChannel,
-		tokenProvider: tokenProvider,
+		httpClient:        client,
+		credentials:       credentials,
+		cache:             newTokenCache(),
+		fastChannel:       fastChannel,
+		tokenProvider:     tokenProvider,
+		oauth2:            options.OAuth2,
+		refreshTokens:     make(map[string]string),
+		resolved:          make(map[credentialTarget]Credentials),
+		fastChannelCache:  newFastChannelCache(),
+		anonymousFallback: options.AnonymousFallback,
 	}
+
+	if options.TokenCacheFile != "" {
+		auth.cache = newFileTokenCache(options.TokenCacheFile)
+	}
+
 	return auth
 }
@@ -1,5 +1,15 @@
 package auth
 
+import "context"
+
 type Authenticator interface {
-	Authenticate(challenge *Challenge, ignoreCached bool) (Token, error)
+	Authenticate(ctx context.Context, challenge *Challenge, ignoreCached bool) (Token, error)
+}
+
+// InvalidatingAuthenticator is implemented by authenticators caching tokens.
+// It is not part of Authenticator, so existing implementations keep working.
+type InvalidatingAuthenticator interface {
+	Authenticator
+	// Invalidate drops all cached tokens and refresh tokens.
+	Invalidate()
 }
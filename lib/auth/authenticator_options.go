@@ -0,0 +1,14 @@
+package auth
+
+// AuthenticatorOptions configures the optional features of an authenticator.
+// The zero value disables all of them.
+type AuthenticatorOptions struct {
+	OAuth2 OAuth2Options
+	// TokenCacheFile, if set, persists tokens in a file shared by all
+	// processes using it. A private ".key" file next to it holds the secret
+	// accounts are keyed with.
+	TokenCacheFile string
+	// AnonymousFallback, if set, is called whenever rejected credentials are
+	// replaced by an anonymous pull token; the fallback is disabled if nil.
+	AnonymousFallback AnonymousFallbackHandler
+}
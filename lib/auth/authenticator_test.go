@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kspeeder/docker-registry/lib/registrytest"
+)
+
+type testCredentials struct {
+	user, password, identityToken string
+	lookups                       atomic.Int32
+}
+
+func (c *testCredentials) Credentials(ctx context.Context, registry, repository string) (Credentials, bool, error) {
+	c.lookups.Add(1)
+	return Credentials{Username: c.user, Password: c.password, IdentityToken: c.identityToken}, true, nil
+}
+
+func TestOAuth2PasswordGrantCapturesRefreshToken(t *testing.T) {
+	users := map[string]string{"user": "password"}
+	registry := registrytest.New(registrytest.Options{
+		Auth:  registrytest.AuthToken,
+		Users: users,
+	})
+	defer registry.Close()
+
+	var captured []string
+	credentials := &testCredentials{user: "user", password: "password"}
+	authenticator := NewAuthenticator(registry.Client(), credentials, false, nil, AuthenticatorOptions{
+		OAuth2: OAuth2Options{
+			OfflineAccess: true,
+			RefreshTokenHandler: func(username, refreshToken string) {
+				captured = append(captured, refreshToken)
+			},
+		},
+	})
+
+	challenge, err := ParseChallenge(fmt.Sprintf(`Bearer realm="%s/token",service="registrytest",scope="repository:foo:pull"`, registry.URL()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := authenticator.Authenticate(context.Background(), challenge, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(captured) != 1 || captured[0] != "refresh-user" {
+		t.Fatalf("expected the refresh token to be captured, got %v", captured)
+	}
+
+	// Later logins must use the refresh token instead of the password.
+	users["user"] = "rotated"
+	if _, err := authenticator.Authenticate(context.Background(), challenge, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(captured) != 1 {
+		t.Fatalf("an unchanged refresh token should not be reported again, got %v", captured)
+	}
+
+	// Refresh tokens belong to the auth service that issued them.
+	other, err := ParseChallenge(fmt.Sprintf(`Bearer realm="%s/token",service="other",scope="repository:foo:pull"`, registry.URL()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := authenticator.Authenticate(context.Background(), other, true); err == nil {
+		t.Fatal("the refresh token should not be sent to another service")
+	}
+}
+
+func TestConcurrentAuthenticateSharesTokenFetch(t *testing.T) {
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte(`{"token":"abc"}`))
+	}))
+	defer server.Close()
+
+	authenticator := NewAuthenticator(server.Client(), &testCredentials{user: "user", password: "password"}, false, nil, AuthenticatorOptions{})
+	challenge, err := ParseChallenge(fmt.Sprintf(`Bearer realm="%s/token",service="example",scope="repository:foo:pull"`, server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if token, err := authenticator.Authenticate(context.Background(), challenge, true); err != nil || token.Value() != "abc" {
+				t.Errorf("unexpected token %v, %v", token, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if fetches.Load() != 1 {
+		t.Fatalf("expected concurrent token fetches to be coalesced, got %d", fetches.Load())
+	}
+}
+
+func TestCredentialsResolvedOnCacheMiss(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"token":"abc","expires_in":300}`))
+	}))
+	defer server.Close()
+
+	credentials := &testCredentials{user: "user", password: "password"}
+	authenticator := NewAuthenticator(server.Client(), credentials, false, nil, AuthenticatorOptions{})
+	challenge, err := ParseChallenge(fmt.Sprintf(`Bearer realm="%s/token",service="example",scope="repository:foo:pull"`, server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := authenticator.Authenticate(context.Background(), challenge, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if lookups := credentials.lookups.Load(); lookups != 1 {
+		t.Fatalf("expected cached tokens to be served without asking the provider, got %d lookups", lookups)
+	}
+
+	authenticator.(InvalidatingAuthenticator).Invalidate()
+	if _, err := authenticator.Authenticate(context.Background(), challenge, false); err != nil {
+		t.Fatal(err)
+	}
+	if lookups := credentials.lookups.Load(); lookups != 2 {
+		t.Fatalf("expected credentials to be resolved again after invalidation, got %d lookups", lookups)
+	}
+}
+
+type testMirrorProvider struct {
+	calls atomic.Int32
+}
+
+func (p *testMirrorProvider) RequestMirrorToken(ctx context.Context, registryHost string, service string, scope []string) (MirrorToken, error) {
+	p.calls.Add(1)
+
+	if len(scope) == 1 && scope[0] == "repository:mirrored:pull" {
+		return MirrorToken{Value: "mirror", ExpiresAt: time.Now().Add(time.Hour)}, nil
+	}
+
+	return MirrorToken{}, ErrFastChannelUnavailable
+}
+
+func TestFastChannelFallsBackToAuthServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"token":"jwt"}`))
+	}))
+	defer server.Close()
+
+	provider := &testMirrorProvider{}
+	authenticator := NewAuthenticator(server.Client(), &testCredentials{}, true, provider, AuthenticatorOptions{})
+
+	authenticate := func(repository string, ignoreCached bool) string {
+		challenge, err := ParseChallenge(fmt.Sprintf(`Bearer realm="%s/token",service="example",scope="repository:%s:pull"`, server.URL, repository))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		token, err := authenticator.Authenticate(context.Background(), challenge, ignoreCached)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return token.Value()
+	}
+
+	if value := authenticate("mirrored", false); value != "mirror" {
+		t.Fatalf("expected the fast channel token, got %s", value)
+	}
+
+	if value := authenticate("other", true); value != "jwt" {
+		t.Fatalf("expected the auth server token, got %s", value)
+	}
+
+	authenticate("mirrored", false)
+	authenticate("other", true)
+
+	if calls := provider.calls.Load(); calls != 2 {
+		t.Fatalf("expected fast channel tokens and unavailable scopes to be cached, got %d calls", calls)
+	}
+}
+
+func TestRejectedFastChannelTokenFallsBackToAuthServer(t *testing.T) {
+	registry := registrytest.New(registrytest.Options{
+		Auth:  registrytest.AuthToken,
+		Users: map[string]string{"user": "password"},
+	})
+	defer registry.Close()
+	registry.AddImage("mirrored", "latest", []byte("layer"))
+
+	provider := &testMirrorProvider{}
+	authenticator := NewAuthenticator(registry.Client(), &testCredentials{user: "user", password: "password"}, true, provider, AuthenticatorOptions{})
+
+	tagsUrl := registry.URL().String() + "/v2/mirrored/tags/list"
+	get := func(token Token) int {
+		request, _ := http.NewRequest(http.MethodGet, tagsUrl, nil)
+		request.Header.Set("Authorization", "Bearer "+token.Value())
+
+		response, err := registry.Client().Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+
+		return response.StatusCode
+	}
+
+	challenge, err := ParseChallenge(fmt.Sprintf(`Bearer realm="%s/token",service="registrytest",scope="repository:mirrored:pull"`, registry.URL()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := authenticator.Authenticate(context.Background(), challenge, false)
+	if err != nil || token.Value() != "mirror" {
+		t.Fatalf("expected the fast channel token, got %v, %v", token, err)
+	}
+
+	if status := get(token); status != http.StatusUnauthorized {
+		t.Fatalf("the registry should reject the mirror token, got %d", status)
+	}
+
+	// The connector asks again, ignoring cached tokens.
+	token, err = authenticator.Authenticate(context.Background(), challenge, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status := get(token); status != http.StatusOK {
+		t.Fatalf("expected the auth server token to be accepted, got %d", status)
+	}
+
+	if fetches := registry.TokenRequests(); fetches != 1 {
+		t.Fatalf("expected one auth server token, got %d token requests", fetches)
+	}
+}
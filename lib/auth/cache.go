@@ -1,8 +1,10 @@
 package auth
 
 import (
-	"sort"
-	"strings"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"sync"
 )
 
@@ -10,46 +12,109 @@ type challengeCacheKey struct {
 	realm   string
 	service string
 	scopes  string
+	account string
+}
+
+type realmAccount struct {
+	realm   string
+	account string
 }
 
 type tokenCache struct {
 	entries map[challengeCacheKey]authResponse
+	secret  []byte
 	mutex   sync.RWMutex
 }
 
-func newCacheKey(challenge *Challenge) challengeCacheKey {
-	scopes := append([]string(nil), challenge.scope...)
-	sort.Sort(sort.StringSlice(scopes))
-
+func newCacheKey(challenge *Challenge, account string) challengeCacheKey {
 	return challengeCacheKey{
 		realm:   challenge.realm.String(),
 		service: challenge.service,
-		scopes:  strings.Join(scopes, " "),
+		scopes:  JoinScopes(challenge.scope...),
+		account: account,
 	}
 }
 
-func (c *tokenCache) Get(challenge *Challenge) (token string) {
-	key := newCacheKey(challenge)
+// accountKey identifies the credentials a token was issued for without
+// revealing them, so a shared cache never serves tokens to a process holding
+// other secrets for the same user. Keying the HMAC with a secret of the cache
+// keeps the password from being guessed from the key. Anonymous access has
+// the empty key.
+func accountKey(secret []byte, credentials Credentials) string {
+	if credentials.IsBlank() {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(credentials.Username + "\x00" + credentials.Password + "\x00" + credentials.IdentityToken))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newAccountSecret() []byte {
+	secret := make([]byte, 32)
+	rand.Read(secret)
 
+	return secret
+}
+
+func (c *tokenCache) accountKey(credentials Credentials) string {
+	return accountKey(c.secret, credentials)
+}
+
+func (c *tokenCache) Get(key challengeCacheKey) (response authResponse, cached bool) {
 	c.mutex.RLock()
-	if entry, cached := c.entries[key]; cached {
-		token = entry.Token
-	}
+	response, cached = c.entries[key]
 	c.mutex.RUnlock()
 
+	if cached && response.expired() {
+		c.mutex.Lock()
+		delete(c.entries, key)
+		c.mutex.Unlock()
+
+		return authResponse{}, false
+	}
+
 	return
 }
 
-func (c *tokenCache) Set(challenge *Challenge, response authResponse) {
-	key := newCacheKey(challenge)
-
+func (c *tokenCache) Set(key challengeCacheKey, response authResponse) {
 	c.mutex.Lock()
+	c.evictExpired()
 	c.entries[key] = response
 	c.mutex.Unlock()
 }
 
+func (c *tokenCache) Invalidate() {
+	c.mutex.Lock()
+	c.entries = make(map[challengeCacheKey]authResponse)
+	c.mutex.Unlock()
+}
+
+// accounts returns the realm and account of all cached tokens.
+func (c *tokenCache) accounts() map[realmAccount]bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	accounts := make(map[realmAccount]bool)
+	for key := range c.entries {
+		accounts[realmAccount{key.realm, key.account}] = true
+	}
+
+	return accounts
+}
+
+// evictExpired must be called with the write lock held.
+func (c *tokenCache) evictExpired() {
+	for key, entry := range c.entries {
+		if entry.expired() {
+			delete(c.entries, key)
+		}
+	}
+}
+
 func newTokenCache() *tokenCache {
 	return &tokenCache{
 		entries: make(map[challengeCacheKey]authResponse),
+		secret:  newAccountSecret(),
 	}
 }
@@ -3,18 +3,14 @@ package auth
 import (
 	"fmt"
 	"net/url"
-	"regexp"
+	"strings"
 )
 
-var challengeRegex *regexp.Regexp = regexp.MustCompile(
-	`^\s*Bearer\s+realm="([^"]+)",\s*service="([^"]+)",\s*scope="([^"]+)"\s*`)
-
-var scopeSeparatorRegex *regexp.Regexp = regexp.MustCompile(`\s+`)
-
 type Challenge struct {
-	realm   *url.URL
-	service string
-	scope   []string
+	realm     *url.URL
+	service   string
+	scope     []string
+	errorCode string
 }
 
 func (challenge *Challenge) Realm() *url.URL {
@@ -29,24 +25,77 @@ func (challenge *Challenge) Scope() []string {
 	return challenge.scope
 }
 
+// ErrorCode returns the error parameter of the challenge, e.g.
+// "invalid_token" or "insufficient_scope".
+func (challenge *Challenge) ErrorCode() string {
+	return challenge.errorCode
+}
+
+func (challenge *Challenge) InsufficientScope() bool {
+	return challenge.errorCode == "insufficient_scope"
+}
+
+// Widen returns a copy of the challenge that also covers the given scopes,
+// merging the actions of scopes for the same resource. ok is false if the
+// challenge already covers all of them.
+func (challenge *Challenge) Widen(scopes ...string) (widened *Challenge, ok bool) {
+	merged := mergeScopes(challenge.scope, scopes)
+
+	if strings.Join(merged, " ") == strings.Join(challenge.scope, " ") {
+		return challenge, false
+	}
+
+	widened = &Challenge{
+		realm:   challenge.realm,
+		service: challenge.service,
+		scope:   merged,
+	}
+
+	return widened, true
+}
+
+// ParseChallenge parses the bearer challenge of a WWW-Authenticate header.
 func ParseChallenge(challengeHeader string) (ch *Challenge, err error) {
-	match := challengeRegex.FindAllStringSubmatch(challengeHeader, -1)
+	return ParseChallengeHeaders([]string{challengeHeader})
+}
 
-	if len(match) != 1 {
-		err = fmt.Errorf("malformed challenge header: '%s'", challengeHeader)
-	} else {
-		var parsedRealm *url.URL
-		parsedRealm, err = url.Parse(match[0][1])
+// ParseChallengeHeaders parses the first bearer challenge found in the given
+// WWW-Authenticate header values.
+func ParseChallengeHeaders(headers []string) (ch *Challenge, err error) {
+	challenges, err := ParseAuthenticate(headers)
+	if err != nil {
+		return
+	}
 
-		if err != nil {
-			return
+	for _, challenge := range challenges {
+		if strings.EqualFold(challenge.Scheme, "Bearer") {
+			return newChallenge(challenge)
 		}
+	}
 
-		ch = &Challenge{
-			realm:   parsedRealm,
-			service: match[0][2],
-			scope:   scopeSeparatorRegex.Split(match[0][3], -1),
-		}
+	return nil, fmt.Errorf("no bearer challenge in header: '%s'", strings.Join(headers, ", "))
+}
+
+func newChallenge(raw RawChallenge) (ch *Challenge, err error) {
+	realm, ok := raw.Params["realm"]
+	if !ok || realm == "" {
+		return nil, fmt.Errorf("bearer challenge without realm")
+	}
+
+	parsedRealm, err := url.Parse(realm)
+	if err != nil {
+		return
+	}
+
+	ch = &Challenge{
+		realm:     parsedRealm,
+		service:   raw.Params["service"],
+		scope:     strings.Fields(raw.Params["scope"]),
+		errorCode: raw.Params["error"],
+	}
+
+	if len(ch.scope) == 0 {
+		ch.scope = nil
 	}
 
 	return
@@ -56,7 +105,9 @@ func (c *Challenge) buildRequestUrl() *url.URL {
 	var authUrl url.URL = *c.realm
 	var authParams url.Values = make(map[string][]string)
 
-	authParams.Set("service", c.service)
+	if c.service != "" {
+		authParams.Set("service", c.service)
+	}
 	for _, scope := range c.scope {
 		authParams.Add("scope", scope)
 	}
@@ -65,3 +116,27 @@ func (c *Challenge) buildRequestUrl() *url.URL {
 
 	return &authUrl
 }
+
+// ChallengeSchemes returns the auth scheme of every challenge in the given
+// WWW-Authenticate header values.
+func ChallengeSchemes(headers []string) (schemes []string) {
+	challenges, err := ParseAuthenticate(headers)
+
+	if err != nil {
+		// Still report what the registry asked for if the parameters are
+		// garbled.
+		for _, header := range headers {
+			if fields := strings.Fields(header); len(fields) > 0 {
+				schemes = append(schemes, strings.TrimSuffix(fields[0], ","))
+			}
+		}
+
+		return
+	}
+
+	for _, challenge := range challenges {
+		schemes = append(schemes, challenge.Scheme)
+	}
+
+	return
+}
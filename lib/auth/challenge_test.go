@@ -157,3 +157,88 @@ func TestInvalid(t *testing.T) {
 		t.Fatal("parsing an invalid challenge header should fail")
 	}
 }
+
+func TestParseAnyOrderAndEscapes(t *testing.T) {
+	testcase := `Bearer scope="repository:foo:pull" ,error="invalid_token", realm="https://auth.example.com/token?a=\"b\"", Service=example`
+
+	fixtureUrl, _ := url.Parse(`https://auth.example.com/token?a="b"`)
+	testParse(t, testcase, Challenge{
+		realm:   fixtureUrl,
+		service: "example",
+		scope:   []string{"repository:foo:pull"},
+	})
+}
+
+func TestParseOptionalServiceAndScope(t *testing.T) {
+	fixtureUrl, _ := url.Parse("https://auth.example.com/token")
+	testParse(t, `Bearer realm="https://auth.example.com/token"`, Challenge{
+		realm: fixtureUrl,
+	})
+
+	testAuthUrl(t, `Bearer realm="https://auth.example.com/token"`, "https", "auth.example.com", "/token", nil)
+}
+
+func TestParseMultipleChallenges(t *testing.T) {
+	headers := []string{
+		`Negotiate YII3a+/b==, Basic realm="basic, with comma"`,
+		`Bearer realm="https://auth.example.com/token",service="example"`,
+	}
+
+	challenges, err := ParseAuthenticate(headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(challenges) != 3 ||
+		challenges[0].Token68 != "YII3a+/b==" ||
+		challenges[1].Params["realm"] != "basic, with comma" ||
+		challenges[2].Params["service"] != "example" {
+		t.Fatalf("unexpected challenges %+v", challenges)
+	}
+
+	if schemes := ChallengeSchemes(headers); !reflect.DeepEqual(schemes, []string{"Negotiate", "Basic", "Bearer"}) {
+		t.Fatalf("unexpected schemes %v", schemes)
+	}
+
+	challenge, err := ParseChallengeHeaders(headers)
+	if err != nil || challenge.Service() != "example" {
+		t.Fatalf("bearer challenge not found; got %v, %v", challenge, err)
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	for _, header := range []string{
+		`Bearer realm="https://auth.example.com/token`,
+		`Bearer realm=`,
+		`Bearer service="example"`,
+	} {
+		if _, err := ParseChallenge(header); err == nil {
+			t.Fatalf("parsing %s should fail", header)
+		}
+	}
+}
+
+func TestInsufficientScopeWidening(t *testing.T) {
+	challenge, err := ParseChallenge(`Bearer realm="https://auth.example.com/token",service="example",scope="repository:foo:pull"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	required, err := ParseChallenge(`Bearer realm="https://auth.example.com/token",service="example",scope="repository:foo:push repository:bar:pull",error="insufficient_scope"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !required.InsufficientScope() {
+		t.Fatal("insufficient_scope not detected")
+	}
+
+	widened, ok := challenge.Widen(required.Scope()...)
+	if !ok || !reflect.DeepEqual(widened.Scope(), []string{"repository:foo:pull,push", "repository:bar:pull"}) {
+		t.Fatalf("unexpected widened scopes %v", widened.Scope())
+	}
+
+	if _, ok := widened.Widen("repository:foo:push"); ok {
+		t.Fatal("covered scopes should not widen the challenge")
+	}
+}
@@ -0,0 +1,53 @@
+package auth
+
+import "context"
+
+// Credentials are the secrets used to log into a registry. IdentityToken is
+// an OAuth2 refresh token.
+type Credentials struct {
+	Username      string
+	Password      string
+	IdentityToken string
+}
+
+func (c Credentials) IsBlank() bool {
+	return c.Username == "" && c.Password == "" && c.IdentityToken == ""
+}
+
+// CredentialProvider resolves the credentials for a repository of a registry
+// host. The repository is empty for registry wide requests. ok is false if
+// the provider has no credentials for them.
+type CredentialProvider interface {
+	Credentials(ctx context.Context, registry, repository string) (credentials Credentials, ok bool, err error)
+}
+
+type credentialTarget struct {
+	registry   string
+	repository string
+}
+
+type credentialTargetKey struct{}
+
+// WithCredentialTarget names the registry host and repository credentials
+// are resolved for while authenticating with the returned context.
+func WithCredentialTarget(ctx context.Context, registry, repository string) context.Context {
+	return context.WithValue(ctx, credentialTargetKey{}, credentialTarget{
+		registry:   registry,
+		repository: repository,
+	})
+}
+
+func credentialTargetFromContext(ctx context.Context, c *Challenge) (target credentialTarget) {
+	target, _ = ctx.Value(credentialTargetKey{}).(credentialTarget)
+
+	if target.repository == "" {
+		for _, scope := range c.scope {
+			if parsed, err := ParseScope(scope); err == nil && parsed.Type == "repository" {
+				target.repository = parsed.Name
+				break
+			}
+		}
+	}
+
+	return
+}
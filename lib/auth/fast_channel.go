@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// After a failure, the fast channel is not asked again for the same host and
+// scope for this long. Scopes the provider does not serve are remembered for
+// fastChannelUnavailableFor.
+const (
+	fastChannelRetryAfter     = 30 * time.Second
+	fastChannelUnavailableFor = 10 * time.Minute
+)
+
+type fastChannelEntry struct {
+	response   authResponse
+	err        error
+	retryAfter time.Time
+}
+
+// fastChannelCache remembers fast channel tokens and failures per host and
+// scope set.
+type fastChannelCache struct {
+	entries map[string]fastChannelEntry
+	mutex   sync.Mutex
+}
+
+func (c *fastChannelCache) Get(key string) (entry fastChannelEntry, cached bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, cached = c.entries[key]
+	if !cached {
+		return
+	}
+
+	if entry.err != nil && time.Now().After(entry.retryAfter) || entry.err == nil && entry.response.expired() {
+		delete(c.entries, key)
+		return entry, false
+	}
+
+	return
+}
+
+func (c *fastChannelCache) Set(key string, entry fastChannelEntry) {
+	c.mutex.Lock()
+	c.entries[key] = entry
+	c.mutex.Unlock()
+}
+
+func (c *fastChannelCache) Invalidate() {
+	c.mutex.Lock()
+	c.entries = make(map[string]fastChannelEntry)
+	c.mutex.Unlock()
+}
+
+func newFastChannelCache() *fastChannelCache {
+	return &fastChannelCache{
+		entries: make(map[string]fastChannelEntry),
+	}
+}
+
+// fetchFastChannelToken returns the fast channel token of the challenge. If
+// ignoreCached is set, the cached token was rejected or is being refreshed;
+// it is dropped, and the provider handing out the same token again counts as
+// a failure, so authentication falls back to the auth server.
+func (a *authenticator) fetchFastChannelToken(ctx context.Context, challenge *Challenge, ignoreCached bool) (decodedResponse authResponse, err error) {
+	if a.tokenProvider == nil {
+		err = errors.New("fast channel token provider is not configured")
+		return
+	}
+
+	realm := challenge.Realm()
+	key := realm.Host + " " + JoinScopes(challenge.Scope()...)
+
+	var previous string
+	if entry, cached := a.fastChannelCache.Get(key); cached {
+		if entry.err != nil || !ignoreCached {
+			return entry.response, entry.err
+		}
+
+		previous = entry.response.Token
+	}
+
+	mirrorToken, err := a.tokenProvider.RequestMirrorToken(ctx, realm.Host, challenge.Service(), challenge.Scope())
+	if err == nil && mirrorToken.Value == "" {
+		err = errors.New("fast channel token provider returned an empty token")
+	}
+	if err == nil && previous != "" && mirrorToken.Value == previous {
+		err = errors.New("fast channel token was rejected by the registry")
+	}
+
+	if err != nil {
+		// Cancellation of this request says nothing about the fast channel.
+		if ctx.Err() == nil {
+			retryAfter := fastChannelRetryAfter
+			if errors.Is(err, ErrFastChannelUnavailable) {
+				retryAfter = fastChannelUnavailableFor
+			}
+
+			a.fastChannelCache.Set(key, fastChannelEntry{
+				err:        err,
+				retryAfter: time.Now().Add(retryAfter),
+			})
+		}
+		return
+	}
+
+	decodedResponse = authResponse{
+		Token:     mirrorToken.Value,
+		ExpiresAt: mirrorToken.ExpiresAt,
+	}
+	if decodedResponse.ExpiresAt.IsZero() {
+		decodedResponse.computeExpiry(time.Now())
+	}
+
+	a.fastChannelCache.Set(key, fastChannelEntry{response: decodedResponse})
+
+	return
+}
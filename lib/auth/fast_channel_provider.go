@@ -1,6 +1,51 @@
 package auth
 
+import (
+	"context"
+	"time"
+)
+
+// MirrorToken is a token issued by a fast channel token provider. A zero
+// ExpiresAt applies the default token lifetime.
+type MirrorToken struct {
+	Value     string
+	ExpiresAt time.Time
+}
+
+// FastChannelUnavailableError is returned by fast channel token providers
+// that do not serve a scope; authentication falls back to the auth server.
+type FastChannelUnavailableError string
+
+const ErrFastChannelUnavailable FastChannelUnavailableError = "fast channel token not available for this scope"
+
+func (e FastChannelUnavailableError) Error() string {
+	return string(e)
+}
+
 // FastChannelTokenProvider fetches tokens for fast channel auth flows.
 type FastChannelTokenProvider interface {
+	RequestMirrorToken(ctx context.Context, registryHost string, service string, scope []string) (MirrorToken, error)
+}
+
+// LegacyFastChannelTokenProvider is the former provider interface without
+// context and expiry.
+type LegacyFastChannelTokenProvider interface {
 	RequestMirrorToken(registryHost string, service string, scope []string) (string, error)
 }
+
+type legacyFastChannelTokenProvider struct {
+	provider LegacyFastChannelTokenProvider
+}
+
+func (l *legacyFastChannelTokenProvider) RequestMirrorToken(ctx context.Context, registryHost string, service string, scope []string) (token MirrorToken, err error) {
+	token.Value, err = l.provider.RequestMirrorToken(registryHost, service, scope)
+	return
+}
+
+// AdaptLegacyFastChannelTokenProvider wraps a provider implementing the
+// former interface.
+func AdaptLegacyFastChannelTokenProvider(provider LegacyFastChannelTokenProvider) FastChannelTokenProvider {
+	return &legacyFastChannelTokenProvider{
+		provider: provider,
+	}
+}
@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type fileCacheEntry struct {
+	Realm     string    `json:"realm"`
+	Service   string    `json:"service"`
+	Scopes    string    `json:"scopes"`
+	Account   string    `json:"account"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (e *fileCacheEntry) matches(key challengeCacheKey) bool {
+	return e.Realm == key.realm && e.Service == key.service && e.Scopes == key.scopes && e.Account == key.account
+}
+
+// fileTokenCache persists tokens in a file shared by all processes using the
+// same path. Tokens are only served to the credentials they were issued for.
+// The file is a cache; failing to read or write it is not an error.
+type fileTokenCache struct {
+	path       string
+	memory     *tokenCache
+	secret     []byte
+	secretOnce sync.Once
+}
+
+// accountKey keys account hashes with a secret shared through a private file
+// next to the cache. Without it, tokens are kept to this process.
+func (c *fileTokenCache) accountKey(credentials Credentials) string {
+	c.secretOnce.Do(func() {
+		c.secret = c.loadSecret()
+	})
+
+	return accountKey(c.secret, credentials)
+}
+
+func (c *fileTokenCache) loadSecret() []byte {
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return c.memory.secret
+	}
+
+	unlock, err := lockFile(c.path, true)
+	if err != nil {
+		return c.memory.secret
+	}
+	defer unlock()
+
+	path := c.path + ".key"
+	if secret, err := os.ReadFile(path); err == nil && len(secret) == len(c.memory.secret) {
+		return secret
+	}
+
+	secret := newAccountSecret()
+	if os.WriteFile(path, secret, 0600) != nil {
+		return c.memory.secret
+	}
+
+	return secret
+}
+
+func (c *fileTokenCache) Get(key challengeCacheKey) (response authResponse, cached bool) {
+	if response, cached = c.memory.Get(key); cached {
+		return
+	}
+
+	unlock, err := lockFile(c.path, false)
+	if err != nil {
+		return
+	}
+	entries := c.read()
+	unlock()
+
+	for _, entry := range entries {
+		if entry.matches(key) && time.Now().Before(entry.ExpiresAt) {
+			response = authResponse{
+				Token:     entry.Token,
+				ExpiresAt: entry.ExpiresAt,
+			}
+			c.memory.Set(key, response)
+
+			return response, true
+		}
+	}
+
+	return
+}
+
+func (c *fileTokenCache) Set(key challengeCacheKey, response authResponse) {
+	c.memory.Set(key, response)
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return
+	}
+
+	unlock, err := lockFile(c.path, true)
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	now := time.Now()
+
+	entries := []fileCacheEntry{{
+		Realm:     key.realm,
+		Service:   key.service,
+		Scopes:    key.scopes,
+		Account:   key.account,
+		Token:     response.Token,
+		ExpiresAt: response.ExpiresAt,
+	}}
+
+	for _, entry := range c.read() {
+		if !entry.matches(key) && now.Before(entry.ExpiresAt) {
+			entries = append(entries, entry)
+		}
+	}
+
+	c.write(entries)
+}
+
+// Invalidate drops the tokens this process obtained with credentials. Tokens
+// of other accounts, and anonymous ones, stay for the other processes.
+func (c *fileTokenCache) Invalidate() {
+	accounts := c.memory.accounts()
+	c.memory.Invalidate()
+
+	for account := range accounts {
+		if account.account == "" {
+			delete(accounts, account)
+		}
+	}
+
+	if len(accounts) == 0 {
+		return
+	}
+
+	unlock, err := lockFile(c.path, true)
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	var entries []fileCacheEntry
+	for _, entry := range c.read() {
+		if !accounts[realmAccount{entry.Realm, entry.Account}] {
+			entries = append(entries, entry)
+		}
+	}
+
+	c.write(entries)
+}
+
+func (c *fileTokenCache) read() (entries []fileCacheEntry) {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return
+	}
+
+	if json.Unmarshal(data, &entries) != nil {
+		return nil
+	}
+
+	return
+}
+
+// write replaces the cache file atomically, so readers never see a partial
+// file.
+func (c *fileTokenCache) write(entries []fileCacheEntry) {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return
+	}
+
+	temporary, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(temporary.Name())
+
+	_, err = temporary.Write(data)
+	if closeErr := temporary.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		os.Rename(temporary.Name(), c.path)
+	}
+}
+
+func newFileTokenCache(path string) *fileTokenCache {
+	return &fileTokenCache{
+		path:   path,
+		memory: newTokenCache(),
+	}
+}
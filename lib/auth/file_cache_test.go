@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileTokenCacheSharedAcrossInstances(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache", "tokens.json")
+	challenge := &Challenge{
+		realm:   &url.URL{Scheme: "https", Host: "auth.example.com", Path: "/token"},
+		service: "example",
+		scope:   []string{"repository:foo:pull"},
+	}
+
+	credentials := Credentials{Username: "user", Password: "password"}
+	writer := newFileTokenCache(path)
+	key := newCacheKey(challenge, writer.accountKey(credentials))
+	writer.Set(key, authResponse{Token: "abc", ExpiresAt: time.Now().Add(time.Minute)})
+
+	for _, file := range []string{path, path + ".key"} {
+		if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0600 {
+			t.Fatalf("%s should be private; got %v, %v", file, info, err)
+		}
+	}
+
+	reader := newFileTokenCache(path)
+	if response, cached := reader.Get(newCacheKey(challenge, reader.accountKey(credentials))); !cached || response.Token != "abc" {
+		t.Fatalf("token not shared through the file; got %+v", response)
+	}
+
+	for _, other := range []Credentials{
+		{Username: "other", Password: "password"},
+		{Username: "user", Password: "rotated"},
+		{},
+	} {
+		if _, cached := reader.Get(newCacheKey(challenge, reader.accountKey(other))); cached {
+			t.Fatalf("tokens must not be shared with %+v", other)
+		}
+	}
+
+	if reader.accountKey(Credentials{IdentityToken: "refresh"}) == reader.accountKey(Credentials{}) {
+		t.Fatal("identity token logins must not share tokens with anonymous access")
+	}
+
+	// The key is an HMAC under the cache's secret, not a bare hash.
+	if key.account == accountKey(nil, credentials) || key.account == newTokenCache().accountKey(credentials) {
+		t.Fatal("account keys must depend on the cache secret")
+	}
+
+	if data, _ := os.ReadFile(path); strings.Contains(string(data), "password") || strings.Contains(string(data), `"user"`) {
+		t.Fatalf("the cache file must not reveal the account, got %s", data)
+	}
+
+	writer.Set(key, authResponse{Token: "old", ExpiresAt: time.Now().Add(-time.Second)})
+	if _, cached := newFileTokenCache(path).Get(key); cached {
+		t.Fatal("expired tokens should not be served")
+	}
+}
+
+func TestFileTokenCacheInvalidateKeepsOtherAccounts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	challenge := &Challenge{
+		realm:   &url.URL{Scheme: "https", Host: "auth.example.com", Path: "/token"},
+		service: "example",
+		scope:   []string{"repository:foo:pull"},
+	}
+	expiresAt := time.Now().Add(time.Minute)
+
+	cache := newFileTokenCache(path)
+	own := newCacheKey(challenge, cache.accountKey(Credentials{Username: "user", Password: "password"}))
+	other := newCacheKey(challenge, cache.accountKey(Credentials{Username: "other", Password: "password"}))
+	anonymous := newCacheKey(challenge, "")
+
+	cache.Set(own, authResponse{Token: "own", ExpiresAt: expiresAt})
+	cache.Set(anonymous, authResponse{Token: "anonymous", ExpiresAt: expiresAt})
+	newFileTokenCache(path).Set(other, authResponse{Token: "other", ExpiresAt: expiresAt})
+
+	cache.Invalidate()
+
+	if _, cached := newFileTokenCache(path).Get(own); cached {
+		t.Fatal("the invalidated account should have no tokens left")
+	}
+
+	for _, key := range []challengeCacheKey{other, anonymous} {
+		if _, cached := newFileTokenCache(path).Get(key); !cached {
+			t.Fatalf("tokens of %q should be kept", key.account)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+//go:build !unix
+
+package auth
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+const (
+	lockRetryInterval = 10 * time.Millisecond
+	lockTimeout       = 5 * time.Second
+	// Lock files older than this are left over by crashed processes.
+	staleLockAge = 30 * time.Second
+)
+
+// lockFile emulates an exclusive lock by creating a lock file next to path.
+// Shared locks are exclusive as well.
+func lockFile(path string, exclusive bool) (unlock func(), err error) {
+	lockPath := path + ".lock"
+	deadline := time.Now().Add(lockTimeout)
+
+	for {
+		var file *os.File
+		file, err = os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+
+		if !errors.Is(err, os.ErrExist) {
+			return
+		}
+
+		if info, statErr := os.Stat(lockPath); statErr == nil && time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(lockPath)
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, errors.New("timed out waiting for token cache lock")
+		}
+
+		time.Sleep(lockRetryInterval)
+	}
+}
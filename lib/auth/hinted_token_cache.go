@@ -11,7 +11,7 @@ type hintedTokenCache struct {
 
 func (c *hintedTokenCache) Get(hint string) (token string) {
 	c.mutex.RLock()
-	if entry, cached := c.entries[hint]; cached {
+	if entry, cached := c.entries[hint]; cached && !entry.expired() {
 		token = entry.Token
 	}
 	c.mutex.RUnlock()
@@ -0,0 +1,24 @@
+package auth
+
+const DefaultOAuth2ClientId = "docker-ls"
+
+// OAuth2Options configures the OAuth2 token flows.
+type OAuth2Options struct {
+	// ClientId is sent with every OAuth2 request; DefaultOAuth2ClientId if
+	// empty.
+	ClientId string
+	// OfflineAccess logs in with the password grant and access_type=offline
+	// to obtain a refresh token, instead of fetching tokens with basic auth.
+	OfflineAccess bool
+	// RefreshTokenHandler, if set, is called whenever the auth server issues
+	// a new refresh token for the account.
+	RefreshTokenHandler func(username, refreshToken string)
+}
+
+func (o *OAuth2Options) clientId() string {
+	if o.ClientId == "" {
+		return DefaultOAuth2ClientId
+	}
+
+	return o.ClientId
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	ActionPull   = "pull"
+	ActionPush   = "push"
+	ActionDelete = "delete"
+	ActionAll    = "*"
+)
+
+// Scope is a resource scope of the token authentication specification, e.g.
+// repository:library/alpine:pull,push.
+type Scope struct {
+	Type    string
+	Name    string
+	Actions []string
+}
+
+func RepositoryScope(repository string, actions ...string) Scope {
+	return Scope{
+		Type:    "repository",
+		Name:    repository,
+		Actions: actions,
+	}
+}
+
+// CatalogScope is the scope required to list repositories.
+func CatalogScope() Scope {
+	return Scope{
+		Type:    "registry",
+		Name:    "catalog",
+		Actions: []string{ActionAll},
+	}
+}
+
+func (s Scope) String() string {
+	return s.Type + ":" + s.Name + ":" + strings.Join(s.Actions, ",")
+}
+
+// ParseScope parses a scope string. Names may contain colons, e.g. for
+// registries with a port in the repository name.
+func ParseScope(value string) (scope Scope, err error) {
+	first, last := strings.Index(value, ":"), strings.LastIndex(value, ":")
+	if first <= 0 || first == last || last == len(value)-1 {
+		err = fmt.Errorf("malformed scope '%s'", value)
+		return
+	}
+
+	return Scope{
+		Type:    value[:first],
+		Name:    value[first+1 : last],
+		Actions: strings.Split(value[last+1:], ","),
+	}, nil
+}
+
+// CanonicalScopes merges the actions of scopes for the same resource and
+// sorts resources and actions, so equal scope sets compare equal.
+func CanonicalScopes(scopes ...string) []string {
+	merged := mergeScopes(nil, scopes)
+
+	for i, scope := range merged {
+		if parsed, err := ParseScope(scope); err == nil {
+			sort.Strings(parsed.Actions)
+			merged[i] = parsed.String()
+		}
+	}
+	sort.Strings(merged)
+
+	return merged
+}
+
+// JoinScopes returns the canonical string form of a scope set.
+func JoinScopes(scopes ...string) string {
+	return strings.Join(CanonicalScopes(scopes...), " ")
+}
+
+// mergeScopes adds the scopes of extra to scopes. Scopes have the form
+// type:name:actions; actions for the same type and name are combined.
+func mergeScopes(scopes, extra []string) (merged []string) {
+	resources := make(map[string]int)
+
+	for _, scope := range append(append([]string(nil), scopes...), extra...) {
+		separator := strings.LastIndex(scope, ":")
+		if separator < 0 {
+			merged = appendMissing(merged, scope)
+			continue
+		}
+
+		resource, actions := scope[:separator], strings.Split(scope[separator+1:], ",")
+
+		index, seen := resources[resource]
+		if !seen {
+			resources[resource] = len(merged)
+			merged = append(merged, resource+":"+strings.Join(actions, ","))
+			continue
+		}
+
+		current := strings.Split(merged[index][separator+1:], ",")
+		for _, action := range actions {
+			current = appendMissing(current, action)
+		}
+		merged[index] = resource + ":" + strings.Join(current, ",")
+	}
+
+	return
+}
+
+func appendMissing(values []string, value string) []string {
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
+	}
+
+	return append(values, value)
+}
@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseScope(t *testing.T) {
+	scope, err := ParseScope("repository:localhost:5000/org/app:pull,push")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(scope, RepositoryScope("localhost:5000/org/app", ActionPull, ActionPush)) {
+		t.Fatalf("unexpected scope %+v", scope)
+	}
+
+	for _, malformed := range []string{"repository", "repository:foo", "repository:foo:", ":foo:pull"} {
+		if _, err := ParseScope(malformed); err == nil {
+			t.Fatalf("parsing %s should fail", malformed)
+		}
+	}
+}
+
+func TestJoinScopesIsCanonical(t *testing.T) {
+	a := JoinScopes("repository:b:push", "registry:catalog:*", "repository:b:pull", "repository:a:pull")
+	b := JoinScopes("repository:a:pull", "repository:b:pull,push", "registry:catalog:*")
+
+	if a != b || a != "registry:catalog:* repository:a:pull repository:b:pull,push" {
+		t.Fatalf("scope sets should have a canonical form; got %q and %q", a, b)
+	}
+}
@@ -1,13 +1,17 @@
 package auth
 
+import "time"
+
 type Token interface {
 	Value() string
 	Fresh() bool
+	ExpiresAt() time.Time
 }
 
 type token struct {
-	value string
-	fresh bool
+	value     string
+	fresh     bool
+	expiresAt time.Time
 }
 
 func (t *token) Value() string {
@@ -18,9 +22,14 @@ func (t *token) Fresh() bool {
 	return t.fresh
 }
 
-func newToken(value string, fresh bool) Token {
+func (t *token) ExpiresAt() time.Time {
+	return t.expiresAt
+}
+
+func newToken(response authResponse, fresh bool) Token {
 	return &token{
-		value: value,
-		fresh: fresh,
+		value:     response.Token,
+		fresh:     fresh,
+		expiresAt: response.ExpiresAt,
 	}
 }
@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+// TokenAccess returns the access claims of a JWT issued by a distribution
+// token server. ok is false for opaque tokens.
+func TokenAccess(token string) (access []Scope, ok bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return
+	}
+
+	var claims struct {
+		Access *[]struct {
+			Type    string   `json:"type"`
+			Name    string   `json:"name"`
+			Actions []string `json:"actions"`
+		} `json:"access"`
+	}
+	if json.Unmarshal(payload, &claims) != nil || claims.Access == nil {
+		return
+	}
+
+	for _, entry := range *claims.Access {
+		access = append(access, Scope{
+			Type:    entry.Type,
+			Name:    entry.Name,
+			Actions: entry.Actions,
+		})
+	}
+
+	return access, true
+}
@@ -0,0 +1,11 @@
+package auth
+
+// tokenStore caches auth server responses by challenge and account.
+type tokenStore interface {
+	Get(key challengeCacheKey) (response authResponse, cached bool)
+	Set(key challengeCacheKey, response authResponse)
+	// Invalidate drops the tokens this store obtained with credentials.
+	Invalidate()
+	// accountKey derives the account part of the cache keys.
+	accountKey(credentials Credentials) string
+}
@@ -0,0 +1,63 @@
+package lib
+
+import (
+	"container/list"
+	"sync"
+)
+
+type cachedBlock struct {
+	index int64
+	data  []byte
+}
+
+// blobBlockCache keeps the most recently used blocks of a blob.
+type blobBlockCache struct {
+	capacity int
+	order    *list.List
+	entries  map[int64]*list.Element
+	mutex    sync.Mutex
+}
+
+func (c *blobBlockCache) Get(index int64) (data []byte, ok bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[index]
+	if !ok {
+		return
+	}
+	c.order.MoveToFront(element)
+
+	return element.Value.(*cachedBlock).data, true
+}
+
+func (c *blobBlockCache) Set(index int64, data []byte) {
+	if c.capacity <= 0 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, ok := c.entries[index]; ok {
+		element.Value.(*cachedBlock).data = data
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[index] = c.order.PushFront(&cachedBlock{index: index, data: data})
+
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedBlock).index)
+	}
+}
+
+func newBlobBlockCache(capacity int) *blobBlockCache {
+	return &blobBlockCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[int64]*list.Element),
+	}
+}
@@ -0,0 +1,263 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+const (
+	DefaultBlobBlockSize   = 1 << 20
+	DefaultBlobCacheBlocks = 16
+)
+
+// BlobReaderOptions configures a BlobReaderAt; zero values select the
+// defaults.
+type BlobReaderOptions struct {
+	BlockSize int64
+	// CacheBlocks is the number of recently read blocks kept in memory.
+	CacheBlocks int
+	// ManifestVersion selects the accept headers of blob requests; 2 if
+	// zero.
+	ManifestVersion uint
+}
+
+// BlobReaderAt gives random access to a remote blob. Reads are served in
+// blocks fetched with range requests; missing blocks adjacent to each other
+// are fetched with a single request, and concurrent reads wait for the
+// requests already fetching their blocks.
+type BlobReaderAt struct {
+	ctx             context.Context
+	api             RegistryApi
+	ref             Refspec
+	digest          string
+	manifestVersion uint
+	size            int64
+	blockSize       int64
+	blocks          *blobBlockCache
+	fetches         map[int64]*blockFetch
+	fetchesMutex    sync.Mutex
+	offset          int64
+	offsetMutex     sync.Mutex
+}
+
+// NewBlobReaderAt looks up the size of the blob. ctx is used for all requests
+// of the reader.
+func NewBlobReaderAt(ctx context.Context, api RegistryApi, ref Refspec, digest string, options BlobReaderOptions) (reader *BlobReaderAt, err error) {
+	if options.BlockSize <= 0 {
+		options.BlockSize = DefaultBlobBlockSize
+	}
+	if options.CacheBlocks == 0 {
+		options.CacheBlocks = DefaultBlobCacheBlocks
+	}
+	if options.ManifestVersion == 0 {
+		options.ManifestVersion = 2
+	}
+
+	size, _, _, err := api.BlobInfo(ctx, ref, options.ManifestVersion, digest, nil)
+	if err != nil {
+		return
+	}
+
+	reader = &BlobReaderAt{
+		ctx:             ctx,
+		api:             api,
+		ref:             ref,
+		digest:          digest,
+		manifestVersion: options.ManifestVersion,
+		size:            size,
+		blockSize:       options.BlockSize,
+		blocks:          newBlobBlockCache(options.CacheBlocks),
+		fetches:         make(map[int64]*blockFetch),
+	}
+
+	return
+}
+
+func (r *BlobReaderAt) Size() int64 {
+	return r.size
+}
+
+func (r *BlobReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+
+	end := off + int64(len(p))
+	if end > r.size {
+		end = r.size
+	}
+	if end == off {
+		return 0, nil
+	}
+
+	blocks, err := r.readBlocks(off/r.blockSize, (end-1)/r.blockSize)
+	if err != nil {
+		return
+	}
+
+	skip := off % r.blockSize
+	for _, block := range blocks {
+		n += copy(p[n:end-off], block[skip:])
+		skip = 0
+	}
+
+	if n < len(p) {
+		err = io.EOF
+	}
+
+	return
+}
+
+func (r *BlobReaderAt) Read(p []byte) (n int, err error) {
+	r.offsetMutex.Lock()
+	defer r.offsetMutex.Unlock()
+
+	n, err = r.ReadAt(p, r.offset)
+	r.offset += int64(n)
+
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+
+	return
+}
+
+func (r *BlobReaderAt) Seek(offset int64, whence int) (int64, error) {
+	r.offsetMutex.Lock()
+	defer r.offsetMutex.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return r.offset, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return r.offset, errors.New("negative position")
+	}
+	r.offset = offset
+
+	return offset, nil
+}
+
+// blockFetch is a request for the blocks first to last. It is registered for
+// each of them while in flight, so reads of any of them wait for it.
+type blockFetch struct {
+	first, last int64
+	data        []byte
+	err         error
+	done        chan struct{}
+}
+
+// readBlocks returns the blocks first to last, fetching runs of missing
+// blocks with one request each.
+func (r *BlobReaderAt) readBlocks(first, last int64) (blocks [][]byte, err error) {
+	blocks = make([][]byte, last-first+1)
+
+	for index := first; index <= last; {
+		if data, ok := r.blocks.Get(index); ok {
+			blocks[index-first] = data
+			index++
+			continue
+		}
+
+		fetch, started := r.startFetch(index, last)
+		if started {
+			r.fetchBlocks(fetch)
+		}
+		<-fetch.done
+
+		if fetch.err != nil {
+			return nil, fetch.err
+		}
+
+		for ; index <= min(fetch.last, last); index++ {
+			blocks[index-first] = r.block(fetch.data, index-fetch.first)
+		}
+	}
+
+	return
+}
+
+// startFetch returns the fetch of block index. Unless one is in flight
+// already, it registers a new one for the run of missing blocks from index
+// up to at most last, which the caller has to start.
+func (r *BlobReaderAt) startFetch(index, last int64) (fetch *blockFetch, started bool) {
+	r.fetchesMutex.Lock()
+	defer r.fetchesMutex.Unlock()
+
+	if fetch, ok := r.fetches[index]; ok {
+		return fetch, false
+	}
+
+	// The block may have been stored since the caller looked.
+	if data, ok := r.blocks.Get(index); ok {
+		fetch = &blockFetch{first: index, last: index, data: data, done: make(chan struct{})}
+		close(fetch.done)
+		return fetch, false
+	}
+
+	fetch = &blockFetch{first: index, last: index, done: make(chan struct{})}
+	for fetch.last < last {
+		if _, ok := r.fetches[fetch.last+1]; ok {
+			break
+		}
+		if _, ok := r.blocks.Get(fetch.last + 1); ok {
+			break
+		}
+		fetch.last++
+	}
+
+	for block := fetch.first; block <= fetch.last; block++ {
+		r.fetches[block] = fetch
+	}
+
+	return fetch, true
+}
+
+func (r *BlobReaderAt) fetchBlocks(fetch *blockFetch) {
+	defer close(fetch.done)
+
+	fetch.data, fetch.err = r.fetchRange(fetch.first*r.blockSize, min((fetch.last+1)*r.blockSize, r.size))
+	if fetch.err == nil {
+		for index := fetch.first; index <= fetch.last; index++ {
+			r.blocks.Set(index, r.block(fetch.data, index-fetch.first))
+		}
+	}
+
+	r.fetchesMutex.Lock()
+	for index := fetch.first; index <= fetch.last; index++ {
+		delete(r.fetches, index)
+	}
+	r.fetchesMutex.Unlock()
+}
+
+func (r *BlobReaderAt) fetchRange(start, end int64) ([]byte, error) {
+	response, err := r.api.RangeBlobs(r.ctx, r.ref, r.manifestVersion, r.digest, start, end, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	data := make([]byte, end-start)
+	if _, err := io.ReadFull(response.Body, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// block returns the ith block of a run of blocks.
+func (r *BlobReaderAt) block(run []byte, i int64) []byte {
+	from := i * r.blockSize
+	return run[from:min(from+r.blockSize, int64(len(run)))]
+}
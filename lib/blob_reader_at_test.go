@@ -0,0 +1,120 @@
+package lib
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/kspeeder/docker-registry/lib/registrytest"
+)
+
+func TestBlobReaderAt(t *testing.T) {
+	registry := newTestRegistry(registrytest.AuthToken)
+	defer registry.Close()
+
+	var archive bytes.Buffer
+	writer := zip.NewWriter(&archive)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		file, _ := writer.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		file.Write(bytes.Repeat([]byte(name), 2000))
+	}
+	writer.Close()
+	content := archive.Bytes()
+
+	_, layers := registry.AddImage("foo", "latest", content)
+	api := newTestApi(t, registry, nil)
+
+	reader, err := NewBlobReaderAt(context.Background(), api, NewRefspec("foo", "latest"), layers[0], BlobReaderOptions{
+		BlockSize:   1024,
+		CacheBlocks: 4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reader.Size() != int64(len(content)) {
+		t.Fatalf("unexpected size %d", reader.Size())
+	}
+
+	requests := registry.Requests()
+	buffer := make([]byte, 3000)
+	if n, err := reader.ReadAt(buffer, 100); err != nil || !bytes.Equal(buffer[:n], content[100:3100]) {
+		t.Fatalf("unexpected read of %d bytes: %v", n, err)
+	}
+
+	if registry.Requests() != requests+1 {
+		t.Fatalf("adjacent blocks should be fetched with one request, got %d", registry.Requests()-requests)
+	}
+
+	if n, err := reader.ReadAt(buffer[:500], 2000); err != nil || !bytes.Equal(buffer[:n], content[2000:2500]) || registry.Requests() != requests+1 {
+		t.Fatal("cached blocks should be served without requests")
+	}
+
+	if _, err := reader.Seek(-10, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if tail, err := io.ReadAll(reader); err != nil || !bytes.Equal(tail, content[len(content)-10:]) {
+		t.Fatalf("unexpected tail %q: %v", tail, err)
+	}
+
+	files, err := zip.NewReader(reader, reader.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := files.File[1].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if data, err := io.ReadAll(file); err != nil || !bytes.Equal(data, bytes.Repeat([]byte("b.txt"), 2000)) {
+		t.Fatalf("unexpected archive member content: %v", err)
+	}
+}
+
+func TestBlobReaderAtSharesOverlappingFetches(t *testing.T) {
+	registry := newTestRegistry(registrytest.AuthToken)
+	defer registry.Close()
+
+	content := bytes.Repeat([]byte("0123456789abcdef"), 384)
+	_, layers := registry.AddImage("foo", "latest", content)
+	api := newTestApi(t, registry, nil)
+
+	reader, err := NewBlobReaderAt(context.Background(), api, NewRefspec("foo", "latest"), layers[0], BlobReaderOptions{
+		BlockSize: 1024,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry.SetFaults(registrytest.Faults{SlowBodyDelay: 20 * time.Millisecond, SlowBodyChunk: 512})
+	requests := registry.Requests()
+
+	done := make(chan error)
+	go func() {
+		buffer := make([]byte, 4096)
+		_, err := reader.ReadAt(buffer, 0)
+		done <- err
+	}()
+
+	// Wait until blocks 0-3 are in flight, then read blocks 1-2.
+	for registry.Requests() == requests {
+		time.Sleep(time.Millisecond)
+	}
+
+	buffer := make([]byte, 2048)
+	if n, err := reader.ReadAt(buffer, 1024); err != nil || !bytes.Equal(buffer[:n], content[1024:3072]) {
+		t.Fatalf("unexpected read of %d bytes: %v", n, err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if fetches := registry.Requests() - requests; fetches != 1 {
+		t.Fatalf("blocks in flight should not be fetched again, got %d requests", fetches)
+	}
+}
@@ -0,0 +1,136 @@
+package lib
+
+import (
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Cached storage URLs are dropped this long before they expire, so requests
+// do not race the expiry.
+const blobRedirectExpiryMargin = 10 * time.Second
+
+type blobRedirect struct {
+	url       *url.URL
+	expiresAt time.Time
+}
+
+// blobRedirectCache remembers the presigned storage URLs blob downloads were
+// redirected to, per repository and digest, until they expire. URLs without
+// expiry are kept for ttl, or not at all if ttl is zero.
+type blobRedirectCache struct {
+	ttl     time.Duration
+	entries map[string]blobRedirect
+	mutex   sync.Mutex
+}
+
+func (c *blobRedirectCache) Get(repository, digest string) *url.URL {
+	if c == nil {
+		return nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := repository + "@" + digest
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+
+	if !time.Now().Before(entry.expiresAt) {
+		delete(c.entries, key)
+		return nil
+	}
+
+	copied := *entry.url
+	return &copied
+}
+
+func (c *blobRedirectCache) Set(repository, digest string, storageUrl *url.URL) {
+	if c == nil {
+		return
+	}
+
+	now := time.Now()
+	expiresAt, ok := presignedExpiry(storageUrl)
+	if !ok {
+		if c.ttl <= 0 {
+			return
+		}
+		expiresAt = now.Add(c.ttl)
+	}
+	expiresAt = expiresAt.Add(-blobRedirectExpiryMargin)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key, entry := range c.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+
+	if now.Before(expiresAt) {
+		c.entries[repository+"@"+digest] = blobRedirect{
+			url:       storageUrl,
+			expiresAt: expiresAt,
+		}
+	}
+}
+
+func (c *blobRedirectCache) Remove(repository, digest string) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	delete(c.entries, repository+"@"+digest)
+	c.mutex.Unlock()
+}
+
+// Clear drops all storage URLs, e.g. when they were obtained with credentials
+// that are no longer used.
+func (c *blobRedirectCache) Clear() {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	c.entries = make(map[string]blobRedirect)
+	c.mutex.Unlock()
+}
+
+// presignedExpiry reads the expiry of S3 and GCS style presigned URLs: a
+// signing date plus lifetime in seconds, or an absolute unix time in Expires.
+func presignedExpiry(storageUrl *url.URL) (expiresAt time.Time, ok bool) {
+	query := storageUrl.Query()
+
+	for _, prefix := range []string{"X-Amz-", "X-Goog-"} {
+		lifetime, err := strconv.ParseInt(query.Get(prefix+"Expires"), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		signedAt, err := time.Parse("20060102T150405Z", query.Get(prefix+"Date"))
+		if err != nil {
+			return
+		}
+
+		return signedAt.Add(time.Duration(lifetime) * time.Second), true
+	}
+
+	if expires, err := strconv.ParseInt(query.Get("Expires"), 10, 64); err == nil {
+		return time.Unix(expires, 0), true
+	}
+
+	return
+}
+
+func newBlobRedirectCache(ttl time.Duration) *blobRedirectCache {
+	return &blobRedirectCache{
+		ttl:     ttl,
+		entries: make(map[string]blobRedirect),
+	}
+}
@@ -0,0 +1,43 @@
+package lib
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestPresignedExpiry(t *testing.T) {
+	cases := map[string]time.Time{
+		"https://bucket.s3.amazonaws.com/blob?X-Amz-Date=20240102T030405Z&X-Amz-Expires=600": time.Date(2024, 1, 2, 3, 14, 5, 0, time.UTC),
+		"https://storage.googleapis.com/blob?X-Goog-Date=20240102T030405Z&X-Goog-Expires=60": time.Date(2024, 1, 2, 3, 5, 5, 0, time.UTC),
+		"https://cdn.example/blob?Expires=1704164645&Signature=abc":                          time.Unix(1704164645, 0),
+	}
+
+	for raw, expected := range cases {
+		storageUrl, _ := url.Parse(raw)
+		if expiresAt, ok := presignedExpiry(storageUrl); !ok || !expiresAt.Equal(expected) {
+			t.Errorf("%s: expected expiry %v, got %v", raw, expected, expiresAt)
+		}
+	}
+
+	storageUrl, _ := url.Parse("https://storage.example/blob")
+	if _, ok := presignedExpiry(storageUrl); ok {
+		t.Error("unsigned URLs have no expiry")
+	}
+
+	cache := newBlobRedirectCache(0)
+	cache.Set("foo", "sha256:abc", storageUrl)
+	if cache.Get("foo", "sha256:abc") != nil {
+		t.Error("URLs without expiry must not be cached without a TTL")
+	}
+
+	cache = newBlobRedirectCache(time.Minute)
+	cache.Set("foo", "sha256:abc", storageUrl)
+	cache.Clear()
+	if cache.Get("foo", "sha256:abc") != nil {
+		t.Error("cleared URLs must not be served")
+	}
+
+	// Clearing is safe with caching disabled.
+	(*blobRedirectCache)(nil).Clear()
+}
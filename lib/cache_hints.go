@@ -1,17 +1,30 @@
 package lib
 
+import "github.com/kspeeder/docker-registry/lib/auth"
+
+// Cache hints are the canonical scope set an operation needs; tokens are
+// cached by them.
+
 func cacheHintRegistryList() string {
-	return "catalog:"
+	return auth.CatalogScope().String()
 }
 
 func cacheHintTagList(repository string) string {
-	return "pull:" + repository
+	return auth.RepositoryScope(repository, auth.ActionPull).String()
 }
 
 func cacheHintTagDetails(repository string) string {
-	return "pull:" + repository
+	return auth.RepositoryScope(repository, auth.ActionPull).String()
 }
 
 func cacheHintBlob(repository string) string {
-	return "pull:" + repository
+	return auth.RepositoryScope(repository, auth.ActionPull).String()
+}
+
+func cacheHintDelete(repository string) string {
+	return auth.RepositoryScope(repository, auth.ActionDelete).String()
+}
+
+func cacheHintPush(repository string) string {
+	return auth.RepositoryScope(repository, auth.ActionPull, auth.ActionPush).String()
 }
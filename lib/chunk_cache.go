@@ -0,0 +1,234 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/kspeeder/docker-registry/lib/internal/singleflight"
+	"github.com/opencontainers/go-digest"
+)
+
+// ChunkCacheOptions configures a ChunkCache; zero values select the defaults.
+type ChunkCacheOptions struct {
+	ChunkSize int64
+	// ManifestVersion selects the accept headers of blob requests; 2 if
+	// zero.
+	ManifestVersion uint
+}
+
+// ChunkCache stores blobs as chunk files of a fixed size, in a directory per
+// digest and chunk size below dir, next to the FileMeta of the blob. Caches
+// with different chunk sizes can share dir without disturbing each other.
+// Chunks are fetched with RangeBlobs when first read; concurrent reads of a
+// missing chunk share one fetch.
+type ChunkCache struct {
+	api             RegistryApi
+	dir             string
+	chunkSize       int64
+	manifestVersion uint
+	opens           singleflight.Group[*FileMeta]
+	fetches         singleflight.Group[[]byte]
+}
+
+// CachedBlob reads a blob through a ChunkCache. ctx of Open is used for all
+// fetches.
+type CachedBlob struct {
+	cache      *ChunkCache
+	ctx        context.Context
+	ref        Refspec
+	blobDigest string
+	path       string
+	meta       FileMeta
+}
+
+func NewChunkCache(api RegistryApi, dir string, options ChunkCacheOptions) *ChunkCache {
+	if options.ChunkSize <= 0 {
+		options.ChunkSize = DefaultBlobBlockSize
+	}
+	if options.ManifestVersion == 0 {
+		options.ManifestVersion = 2
+	}
+
+	return &ChunkCache{
+		api:             api,
+		dir:             dir,
+		chunkSize:       options.ChunkSize,
+		manifestVersion: options.ManifestVersion,
+	}
+}
+
+// Open prepares the cache directory of a blob.
+func (c *ChunkCache) Open(ctx context.Context, ref Refspec, blobDigest string) (blob *CachedBlob, err error) {
+	parsed, err := digest.Parse(blobDigest)
+	if err != nil {
+		return
+	}
+	path := filepath.Join(c.dir, parsed.Algorithm().String(), parsed.Encoded(), strconv.FormatInt(c.chunkSize, 10))
+
+	meta, err := ReadFileMeta(path)
+	if err != nil || meta.ChunkSize != c.chunkSize {
+		meta, err, _ = c.opens.Do(ctx, path, func(ctx context.Context) (*FileMeta, error) {
+			return c.initialize(ctx, ref, blobDigest, path)
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	blob = &CachedBlob{
+		cache:      c,
+		ctx:        ctx,
+		ref:        ref,
+		blobDigest: blobDigest,
+		path:       path,
+		meta:       *meta,
+	}
+
+	return
+}
+
+func (c *ChunkCache) initialize(ctx context.Context, ref Refspec, blobDigest, path string) (meta *FileMeta, err error) {
+	size, modTime, _, err := c.api.BlobInfo(ctx, ref, c.manifestVersion, blobDigest, nil)
+	if err != nil {
+		return
+	}
+
+	// Chunks already in the directory stay valid, as blobs never change.
+	if err = os.MkdirAll(path, 0755); err != nil {
+		return
+	}
+
+	meta = &FileMeta{
+		Size:      size,
+		ChunkSize: c.chunkSize,
+	}
+	if !modTime.IsZero() {
+		meta.ModTime = modTime.Unix()
+	}
+
+	err = SaveFileMeta(path, meta)
+
+	return
+}
+
+func (b *CachedBlob) Size() int64 {
+	return b.meta.Size
+}
+
+func (b *CachedBlob) Chunks() int64 {
+	return (b.meta.Size + b.meta.ChunkSize - 1) / b.meta.ChunkSize
+}
+
+// Cached tells if a chunk is stored on disk.
+func (b *CachedBlob) Cached(index int64) bool {
+	info, err := os.Stat(b.chunkPath(index))
+	return err == nil && info.Size() == b.chunkLength(index)
+}
+
+func (b *CachedBlob) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= b.meta.Size {
+		return 0, io.EOF
+	}
+
+	end := min(off+int64(len(p)), b.meta.Size)
+	for position := off; position < end; {
+		index := position / b.meta.ChunkSize
+		chunkOffset := position % b.meta.ChunkSize
+		length := min(end-position, b.chunkLength(index)-chunkOffset)
+
+		var read int
+		read, err = b.readChunk(index, p[n:n+int(length)], chunkOffset)
+		n += read
+		position += int64(read)
+
+		if err != nil {
+			return
+		}
+	}
+
+	if n < len(p) {
+		err = io.EOF
+	}
+
+	return
+}
+
+func (b *CachedBlob) readChunk(index int64, p []byte, offset int64) (int, error) {
+	if file, err := os.Open(b.chunkPath(index)); err == nil {
+		defer file.Close()
+
+		if info, err := file.Stat(); err == nil && info.Size() == b.chunkLength(index) {
+			return file.ReadAt(p, offset)
+		}
+	}
+
+	data, err := b.cache.fetchChunk(b, index)
+	if err != nil {
+		return 0, err
+	}
+
+	return copy(p, data[offset:]), nil
+}
+
+func (c *ChunkCache) fetchChunk(b *CachedBlob, index int64) ([]byte, error) {
+	path := b.chunkPath(index)
+
+	data, err, _ := c.fetches.Do(b.ctx, path, func(ctx context.Context) ([]byte, error) {
+		start := index * b.meta.ChunkSize
+		end := start + b.chunkLength(index)
+
+		response, err := c.api.RangeBlobs(ctx, b.ref, c.manifestVersion, b.blobDigest, start, end, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer response.Body.Close()
+
+		data := make([]byte, end-start)
+		if _, err := io.ReadFull(response.Body, data); err != nil {
+			return nil, err
+		}
+
+		return data, writeChunkFile(path, data)
+	})
+
+	return data, err
+}
+
+// writeChunkFile writes through a temporary file, so readers never see
+// partial chunks.
+func writeChunkFile(path string, data []byte) (err error) {
+	file, err := os.CreateTemp(filepath.Dir(path), ".chunk-*")
+	if err != nil {
+		return
+	}
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+
+	return
+}
+
+func (b *CachedBlob) chunkPath(index int64) string {
+	return filepath.Join(b.path, fmt.Sprintf("chunk-%d", index))
+}
+
+func (b *CachedBlob) chunkLength(index int64) int64 {
+	return min(b.meta.ChunkSize, b.meta.Size-index*b.meta.ChunkSize)
+}
@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/kspeeder/docker-registry/lib/registrytest"
+)
+
+func TestChunkCache(t *testing.T) {
+	registry := newTestRegistry(registrytest.AuthToken)
+	defer registry.Close()
+
+	content := bytes.Repeat([]byte("0123456789"), 250)
+	_, layers := registry.AddImage("foo", "latest", content)
+	api := newTestApi(t, registry, nil)
+	ref := NewRefspec("foo", "latest")
+	dir := t.TempDir()
+
+	blob, err := NewChunkCache(api, dir, ChunkCacheOptions{ChunkSize: 1024}).Open(context.Background(), ref, layers[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if blob.Size() != int64(len(content)) || blob.Chunks() != 3 || blob.Cached(0) {
+		t.Fatalf("unexpected fresh blob: size %d, %d chunks", blob.Size(), blob.Chunks())
+	}
+
+	buffer := make([]byte, 1000)
+	if n, err := blob.ReadAt(buffer, 500); err != nil || !bytes.Equal(buffer[:n], content[500:1500]) {
+		t.Fatalf("unexpected read across chunks: %v", err)
+	}
+
+	if !blob.Cached(0) || !blob.Cached(1) || blob.Cached(2) {
+		t.Fatal("only the chunks read should be cached")
+	}
+
+	requests := registry.Requests()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			buffer := make([]byte, 100)
+			if n, err := blob.ReadAt(buffer, 2400); err != nil || !bytes.Equal(buffer[:n], content[2400:]) {
+				t.Errorf("unexpected read of the last chunk: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if registry.Requests() != requests+1 {
+		t.Fatalf("concurrent reads of a chunk should share one fetch, got %d requests", registry.Requests()-requests)
+	}
+
+	// Another cache on the same directory serves everything from disk.
+	reopened, err := NewChunkCache(api, dir, ChunkCacheOptions{ChunkSize: 1024}).Open(context.Background(), ref, layers[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all := make([]byte, len(content))
+	if n, err := reopened.ReadAt(all, 0); err != nil || !bytes.Equal(all[:n], content) || registry.Requests() != requests+1 {
+		t.Fatalf("cached blob should be read without requests: %v", err)
+	}
+
+	resized, err := NewChunkCache(api, dir, ChunkCacheOptions{ChunkSize: 512}).Open(context.Background(), ref, layers[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resized.Cached(0) || !IsMetaValid(resized.path, 512, int64(len(content))) {
+		t.Fatal("chunks of another size should not be used")
+	}
+
+	// Blobs already open keep their chunks.
+	requests = registry.Requests()
+	if n, err := reopened.ReadAt(all, 0); err != nil || !bytes.Equal(all[:n], content) || registry.Requests() != requests {
+		t.Fatalf("opening another chunk size must not drop cached chunks: %v", err)
+	}
+}
@@ -3,10 +3,13 @@ package lib
 import (
 	"errors"
 	"flag"
+	"log"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/kspeeder/docker-registry/lib/auth"
+	"github.com/kspeeder/docker-registry/lib/connector"
 )
 
 var DEFAULT_REGISTRY_URL url.URL
@@ -35,12 +38,31 @@ type Config struct {
 	credentials           RegistryCredentials
 	pageSize              uint
 	maxConcurrentRequests uint
+	minConcurrentRequests uint
+	adaptiveConcurrency   bool
 	basicAuth             bool
+	autoAuth              bool
 	allowInsecure         bool
 	userAgent             string
 	httpClient            *http.Client
 	fastChannel           bool
 	tokenProvider         auth.FastChannelTokenProvider
+	middlewares           []connector.Middleware
+	oauth2ClientId        string
+	oauth2Offline         bool
+	persistRefreshToken   bool
+	refreshErrorHandler   func(username string, err error)
+	tokenCacheFile        string
+	anonymousFallback     bool
+	fallbackHandler       auth.AnonymousFallbackHandler
+	cacheBlobRedirects    bool
+	blobRedirectTTL       time.Duration
+	emulateRanges         bool
+	probeWrites           bool
+	mountProbeSource      Refspec
+	credentialProviders   []auth.CredentialProvider
+	dockerConfigProvider  auth.CredentialProvider
+	credentialSwitch      *credentialSwitch
 }
 
 func (u *urlValue) String() string {
@@ -53,9 +75,21 @@ func (c *Config) BindToFlags(flags *flag.FlagSet) {
 	flags.Var((*urlValue)(&c.registryUrl), "registry", "registry URL")
 	flags.UintVar(&c.pageSize, "page-size", c.pageSize, "page size for paginated requests")
 	flags.UintVar(&c.maxConcurrentRequests, "max-requests", c.maxConcurrentRequests, "concurrent API request limit")
+	flags.UintVar(&c.minConcurrentRequests, "min-requests", c.minConcurrentRequests, "lower concurrent API request bound in adaptive mode")
+	flags.BoolVar(&c.adaptiveConcurrency, "adaptive-requests", c.adaptiveConcurrency, "adapt the concurrent API request limit to registry feedback")
 	flags.BoolVar(&c.basicAuth, "basic-auth", c.basicAuth, "use basic auth instead of token auth")
+	flags.BoolVar(&c.autoAuth, "auto-auth", c.autoAuth, "detect the auth scheme from the registry's challenges")
 	flags.BoolVar(&c.allowInsecure, "allow-insecure", c.allowInsecure, "ignore SSL certificate validation errors")
 	flags.StringVar(&c.userAgent, "user-agent", c.userAgent, "override http user-agent header")
+	flags.StringVar(&c.oauth2ClientId, "oauth2-client-id", c.oauth2ClientId, "client id for OAuth2 token requests")
+	flags.BoolVar(&c.oauth2Offline, "oauth2-offline", c.oauth2Offline, "log in with the OAuth2 password grant to obtain a refresh token")
+	flags.StringVar(&c.tokenCacheFile, "token-cache", c.tokenCacheFile, "file to share auth tokens across invocations")
+	flags.BoolVar(&c.anonymousFallback, "anonymous-fallback", c.anonymousFallback, "pull anonymously if the auth server rejects the credentials")
+	flags.BoolVar(&c.cacheBlobRedirects, "cache-blob-redirects", c.cacheBlobRedirects, "send range requests straight to the storage URLs blob downloads were redirected to")
+	flags.DurationVar(&c.blobRedirectTTL, "blob-redirect-ttl", c.blobRedirectTTL, "how long to use redirect URLs without an announced expiry")
+	flags.BoolVar(&c.emulateRanges, "emulate-ranges", c.emulateRanges, "cut blob ranges out of full responses from servers ignoring range requests")
+	flags.BoolVar(&c.probeWrites, "probe-writes", c.probeWrites, "probe delete support and upload limits when querying registry capabilities")
+	flags.BoolVar(&c.persistRefreshToken, "persist-refresh-token", c.persistRefreshToken, "store refresh tokens issued by the registry in the docker credential store")
 
 	c.credentials.BindToFlags(flags)
 }
@@ -64,10 +98,44 @@ func (c *Config) MaxConcurrentRequests() uint {
 	return c.maxConcurrentRequests
 }
 
+func (c *Config) MinConcurrentRequests() uint {
+	return c.minConcurrentRequests
+}
+
+func (c *Config) AdaptiveConcurrency() bool {
+	return c.adaptiveConcurrency
+}
+
 func (c *Config) Credentials() auth.RegistryCredentials {
 	return &c.credentials
 }
 
+// AddCredentialProvider appends a provider asked for credentials if the
+// explicitly set credentials are blank. Providers are asked in the order they
+// were added, and before the docker config.
+func (c *Config) AddCredentialProvider(provider auth.CredentialProvider) {
+	c.credentialProviders = append(c.credentialProviders, provider)
+}
+
+func (c *Config) CredentialProvider() auth.CredentialProvider {
+	if c.credentialSwitch != nil {
+		return c.credentialSwitch
+	}
+
+	return c.credentialChain(&c.credentials)
+}
+
+// credentialChain asks the given credentials, the added providers and then
+// the docker config.
+func (c *Config) credentialChain(credentials *RegistryCredentials) auth.CredentialProvider {
+	providers := append([]auth.CredentialProvider{credentials}, c.credentialProviders...)
+	if c.dockerConfigProvider != nil {
+		providers = append(providers, c.dockerConfigProvider)
+	}
+
+	return NewCredentialProviderChain(providers...)
+}
+
 func (c *Config) AllowInsecure() bool {
 	return c.allowInsecure
 }
@@ -100,6 +168,14 @@ func (c *Config) SetMaxConcurrentRequests(maxRequests uint) {
 	c.maxConcurrentRequests = maxRequests
 }
 
+func (c *Config) SetMinConcurrentRequests(minRequests uint) {
+	c.minConcurrentRequests = minRequests
+}
+
+func (c *Config) SetAdaptiveConcurrency(adaptive bool) {
+	c.adaptiveConcurrency = adaptive
+}
+
 func (c *Config) SetUseBasicAuth(basicAuth bool) {
 	c.basicAuth = basicAuth
 }
@@ -108,6 +184,16 @@ func (c *Config) UseBasicAuth() bool {
 	return c.basicAuth
 }
 
+// SetUseAutoAuth makes the connector detect basic, token or anonymous auth per
+// host from the registry's challenges. It takes precedence over basic auth.
+func (c *Config) SetUseAutoAuth(autoAuth bool) {
+	c.autoAuth = autoAuth
+}
+
+func (c *Config) UseAutoAuth() bool {
+	return c.autoAuth
+}
+
 func (c *Config) SetAllowInsecure(allowInsecure bool) {
 	c.allowInsecure = allowInsecure
 }
@@ -116,6 +202,8 @@ func (c *Config) SetUserAgent(userAgent string) {
 	c.userAgent = userAgent
 }
 
+// SetHttpClient replaces the client created by the connectors. Its requests
+// do not get the configured user agent; middlewares still apply.
 func (c *Config) SetHttpClient(client *http.Client) {
 	c.httpClient = client
 }
@@ -140,6 +228,128 @@ func (c *Config) FastChannelTokenProvider() auth.FastChannelTokenProvider {
 	return c.tokenProvider
 }
 
+// AddMiddleware appends a middleware to the chain wrapping every registry and
+// auth server request. Middlewares run in the order they were added.
+func (c *Config) AddMiddleware(middleware connector.Middleware) {
+	c.middlewares = append(c.middlewares, middleware)
+}
+
+func (c *Config) Middlewares() []connector.Middleware {
+	return c.middlewares
+}
+
+func (c *Config) SetOAuth2ClientId(clientId string) {
+	c.oauth2ClientId = clientId
+}
+
+func (c *Config) SetOAuth2OfflineAccess(offline bool) {
+	c.oauth2Offline = offline
+}
+
+// SetPersistRefreshToken enables writing refresh tokens issued by the auth
+// server back to the docker credential store of the registry.
+func (c *Config) SetPersistRefreshToken(persist bool) {
+	c.persistRefreshToken = persist
+}
+
+// SetRefreshTokenErrorHandler sets a handler for errors storing refresh
+// tokens; without one they are logged.
+func (c *Config) SetRefreshTokenErrorHandler(handler func(username string, err error)) {
+	c.refreshErrorHandler = handler
+}
+
+func (c *Config) OAuth2Options() auth.OAuth2Options {
+	options := auth.OAuth2Options{
+		ClientId:      c.oauth2ClientId,
+		OfflineAccess: c.oauth2Offline,
+	}
+
+	if c.persistRefreshToken {
+		registryUrl := c.registryUrl
+		errorHandler := c.refreshErrorHandler
+
+		options.RefreshTokenHandler = func(username, refreshToken string) {
+			// The token stays usable for this session if storing it fails.
+			if err := storeIdentityToken(registryUrl, username, refreshToken); err != nil {
+				if errorHandler != nil {
+					errorHandler(username, err)
+				} else {
+					log.Printf("cannot store refresh token of %s: %v", username, err)
+				}
+			}
+		}
+	}
+
+	return options
+}
+
+// SetTokenCacheFile enables persisting auth tokens in the given file until
+// they expire, so later processes can skip authentication.
+func (c *Config) SetTokenCacheFile(path string) {
+	c.tokenCacheFile = path
+}
+
+func (c *Config) TokenCacheFile() string {
+	return c.tokenCacheFile
+}
+
+// SetAnonymousFallback makes pulls retry token requests anonymously if the auth
+// server rejects the credentials, e.g. because a stored login expired.
+// Fallbacks are counted in the statistics.
+func (c *Config) SetAnonymousFallback(fallback bool) {
+	c.anonymousFallback = fallback
+}
+
+func (c *Config) AnonymousFallback() bool {
+	return c.anonymousFallback
+}
+
+// SetAnonymousFallbackHandler sets a handler warned about every anonymous
+// fallback.
+func (c *Config) SetAnonymousFallbackHandler(handler auth.AnonymousFallbackHandler) {
+	c.fallbackHandler = handler
+}
+
+func (c *Config) AnonymousFallbackHandler() auth.AnonymousFallbackHandler {
+	return c.fallbackHandler
+}
+
+// SetCacheBlobRedirects makes RangeBlobs remember the presigned storage URL a
+// blob download was redirected to and send further range requests there
+// until it expires. Rejected URLs fall back to the registry.
+func (c *Config) SetCacheBlobRedirects(cache bool) {
+	c.cacheBlobRedirects = cache
+}
+
+// SetBlobRedirectTTL sets how long redirect URLs without an expiry in their
+// query are used; they are not cached if zero.
+func (c *Config) SetBlobRedirectTTL(ttl time.Duration) {
+	c.blobRedirectTTL = ttl
+}
+
+// SetEmulateRanges makes RangeBlobs cut the requested window out of full
+// responses from servers that ignore the Range header, instead of failing
+// with ErrRangeNotSupported. Such hosts are remembered for a few minutes and
+// get no Range headers meanwhile.
+func (c *Config) SetEmulateRanges(emulate bool) {
+	c.emulateRanges = emulate
+}
+
+// SetProbeWrites lets Capabilities probe delete support and the upload chunk
+// minimum, and CheckAccess probe push access. The probes delete a digest no
+// registry stores and open upload sessions, which are cancelled right away;
+// they need push and delete access.
+func (c *Config) SetProbeWrites(probeWrites bool) {
+	c.probeWrites = probeWrites
+}
+
+// SetMountProbeSource names an image in another repository whose first layer
+// Capabilities mounts into the probed repository, when write probes are
+// enabled. A successful mount links the layer into the probed repository.
+func (c *Config) SetMountProbeSource(ref Refspec) {
+	c.mountProbeSource = ref
+}
+
 func (c *Config) Validate() error {
 	if c.pageSize == 0 {
 		return errors.New("pagesize must be nonzero")
@@ -149,6 +359,10 @@ func (c *Config) Validate() error {
 		return errors.New("max requests must be nonzero")
 	}
 
+	if c.adaptiveConcurrency && c.minConcurrentRequests > c.maxConcurrentRequests {
+		return errors.New("min requests must not exceed max requests")
+	}
+
 	return nil
 }
 
@@ -164,7 +378,9 @@ func NewConfig() Config {
 		registryUrl:           DEFAULT_REGISTRY_URL,
 		pageSize:              100,
 		maxConcurrentRequests: 5,
+		minConcurrentRequests: 1,
 		basicAuth:             false,
 		userAgent:             ApplicationName(),
+		oauth2ClientId:        auth.DefaultOAuth2ClientId,
 	}
 }
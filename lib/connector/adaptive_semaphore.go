@@ -0,0 +1,158 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	adaptiveDecreaseFactor     = 0.5
+	adaptiveLatencyTolerance   = 2
+	adaptiveErrorRateThreshold = 0.05
+	adaptiveSmoothing          = 0.2
+)
+
+// adaptiveSemaphore is an AIMD concurrency limiter: the limit grows by roughly
+// one slot per round trip while the registry stays healthy and is cut in half
+// whenever the registry signals overload (429, 503 or timeouts).
+type adaptiveSemaphore struct {
+	mutex        sync.Mutex
+	cond         *sync.Cond
+	inFlight     uint
+	limit        float64
+	min          float64
+	max          float64
+	latency      time.Duration
+	baseline     time.Duration
+	errorRate    float64
+	lastDecrease time.Time
+}
+
+func (s *adaptiveSemaphore) Lock() {
+	s.mutex.Lock()
+	for s.inFlight >= uint(s.limit) {
+		s.cond.Wait()
+	}
+	s.inFlight++
+	s.mutex.Unlock()
+}
+
+func (s *adaptiveSemaphore) Unlock() {
+	s.mutex.Lock()
+	s.inFlight--
+	s.mutex.Unlock()
+
+	s.cond.Broadcast()
+}
+
+func (s *adaptiveSemaphore) Limit() (l uint) {
+	s.mutex.Lock()
+	l = uint(s.limit)
+	s.mutex.Unlock()
+
+	return
+}
+
+func (s *adaptiveSemaphore) Report(latency time.Duration, response *http.Response, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if isOverloadSignal(response, err) {
+		s.observeError()
+
+		// Only back off once per round trip; a burst of failures from the same
+		// window should not collapse the limit to the lower bound.
+		if time.Since(s.lastDecrease) > s.latency {
+			s.limit = clampLimit(s.limit*adaptiveDecreaseFactor, s.min, s.max)
+			s.lastDecrease = time.Now()
+		}
+
+		return
+	}
+
+	if err != nil {
+		return
+	}
+
+	if response.StatusCode >= http.StatusInternalServerError {
+		s.observeError()
+		return
+	}
+
+	s.observeSuccess(latency)
+
+	if s.errorRate < adaptiveErrorRateThreshold && s.latency <= adaptiveLatencyTolerance*s.baseline {
+		grown := clampLimit(s.limit+1/s.limit, s.min, s.max)
+		if uint(grown) > uint(s.limit) {
+			defer s.cond.Broadcast()
+		}
+		s.limit = grown
+	}
+}
+
+func (s *adaptiveSemaphore) observeError() {
+	s.errorRate = s.errorRate*(1-adaptiveSmoothing) + adaptiveSmoothing
+}
+
+func (s *adaptiveSemaphore) observeSuccess(latency time.Duration) {
+	s.errorRate = s.errorRate * (1 - adaptiveSmoothing)
+
+	if s.latency == 0 {
+		s.latency = latency
+	} else {
+		s.latency = time.Duration(float64(s.latency)*(1-adaptiveSmoothing) + float64(latency)*adaptiveSmoothing)
+	}
+
+	if s.baseline == 0 || s.latency < s.baseline {
+		s.baseline = s.latency
+	}
+}
+
+func isOverloadSignal(response *http.Response, err error) bool {
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return true
+		}
+
+		var netErr net.Error
+		return errors.As(err, &netErr) && netErr.Timeout()
+	}
+
+	return response.StatusCode == http.StatusTooManyRequests ||
+		response.StatusCode == http.StatusServiceUnavailable
+}
+
+func clampLimit(limit, min, max float64) float64 {
+	if limit < min {
+		return min
+	}
+
+	if limit > max {
+		return max
+	}
+
+	return limit
+}
+
+func newAdaptiveSemaphore(min, max uint) *adaptiveSemaphore {
+	if min == 0 {
+		min = 1
+	}
+
+	if max < min {
+		max = min
+	}
+
+	s := &adaptiveSemaphore{
+		limit: float64(min),
+		min:   float64(min),
+		max:   float64(max),
+	}
+	s.cond = sync.NewCond(&s.mutex)
+
+	return s
+}
@@ -0,0 +1,124 @@
+package connector
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func reportStatus(s *adaptiveSemaphore, times, status int) {
+	for i := 0; i < times; i++ {
+		s.Report(time.Nanosecond, &http.Response{StatusCode: status}, nil)
+	}
+}
+
+func TestAdaptiveSemaphoreGrowsAdditively(t *testing.T) {
+	s := newAdaptiveSemaphore(1, 4)
+
+	// Each success adds 1/limit: 1, 2, 2.5, 2.9, 3.24.
+	reportStatus(s, 1, http.StatusOK)
+	if limit := s.Limit(); limit != 2 {
+		t.Fatalf("expected limit 2, got %d", limit)
+	}
+
+	reportStatus(s, 2, http.StatusOK)
+	if limit := s.Limit(); limit != 2 {
+		t.Fatalf("expected limit to stay at 2, got %d", limit)
+	}
+
+	reportStatus(s, 1, http.StatusOK)
+	if limit := s.Limit(); limit != 3 {
+		t.Fatalf("expected limit 3, got %d", limit)
+	}
+
+	reportStatus(s, 100, http.StatusOK)
+	if limit := s.Limit(); limit != 4 {
+		t.Fatalf("expected limit to be clamped to 4, got %d", limit)
+	}
+}
+
+func TestAdaptiveSemaphoreDecreasesMultiplicatively(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		response *http.Response
+		err      error
+	}{
+		{"429", &http.Response{StatusCode: http.StatusTooManyRequests}, nil},
+		{"503", &http.Response{StatusCode: http.StatusServiceUnavailable}, nil},
+		{"timeout", nil, context.DeadlineExceeded},
+	} {
+		s := newAdaptiveSemaphore(2, 16)
+		reportStatus(s, 1000, http.StatusOK)
+		if limit := s.Limit(); limit != 16 {
+			t.Fatalf("%s: expected limit 16, got %d", test.name, limit)
+		}
+
+		s.Report(time.Nanosecond, test.response, test.err)
+		if limit := s.Limit(); limit != 8 {
+			t.Fatalf("%s: expected limit to be halved to 8, got %d", test.name, limit)
+		}
+
+		for i := 0; i < 10; i++ {
+			time.Sleep(time.Millisecond)
+			s.Report(time.Nanosecond, test.response, test.err)
+		}
+		if limit := s.Limit(); limit != 2 {
+			t.Fatalf("%s: expected limit to be clamped to 2, got %d", test.name, limit)
+		}
+	}
+}
+
+func TestAdaptiveSemaphoreIgnoresOtherErrors(t *testing.T) {
+	s := newAdaptiveSemaphore(1, 8)
+	reportStatus(s, 10, http.StatusOK)
+	limit := s.Limit()
+
+	// A 500 does not signal overload, but the raised error rate stops growth.
+	reportStatus(s, 1, http.StatusInternalServerError)
+	reportStatus(s, 1, http.StatusOK)
+	if s.Limit() != limit {
+		t.Fatalf("expected limit %d to be kept, got %d", limit, s.Limit())
+	}
+}
+
+func TestAdaptiveSemaphoreBacksOffOncePerRoundTrip(t *testing.T) {
+	s := newAdaptiveSemaphore(1, 16)
+	for i := 0; i < 1000; i++ {
+		s.Report(time.Hour, &http.Response{StatusCode: http.StatusOK}, nil)
+	}
+
+	reportStatus(s, 5, http.StatusTooManyRequests)
+	if limit := s.Limit(); limit != 8 {
+		t.Fatalf("expected a burst of 429s to halve the limit once, got %d", limit)
+	}
+}
+
+func TestAdaptiveSemaphoreLockBlocksAtLimit(t *testing.T) {
+	s := newAdaptiveSemaphore(1, 2)
+	s.Lock()
+
+	acquired := make(chan struct{})
+	go func() {
+		s.Lock()
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("Lock must block while the limit is reached")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Growing the limit wakes up the waiter.
+	reportStatus(s, 1, http.StatusOK)
+
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("Lock did not return after the limit grew")
+	}
+
+	s.Unlock()
+	s.Unlock()
+}
@@ -0,0 +1,191 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/kspeeder/docker-registry/lib/auth"
+)
+
+type authScheme int
+
+const (
+	authSchemeAnonymous authScheme = iota
+	authSchemeBasic
+	authSchemeBearer
+)
+
+// autoAuthConnector pings /v2/ once per host to learn which auth scheme the
+// registry expects and dispatches to the matching connector. Registries that
+// challenge with a different scheme for some repositories are remembered per
+// repository.
+type autoAuthConnector struct {
+	basic     *basicAuthConnector
+	token     *tokenAuthConnector
+	stat      *statistics
+	hosts     map[string]authScheme
+	overrides map[string]authScheme
+	mutex     sync.RWMutex
+}
+
+func (r *autoAuthConnector) Delete(ctx context.Context, url *url.URL, headers map[string]string, hint string) (*http.Response, error) {
+	return r.Request(ctx, http.MethodDelete, url, headers, hint)
+}
+
+func (r *autoAuthConnector) Get(ctx context.Context, url *url.URL, headers map[string]string, hint string) (*http.Response, error) {
+	return r.Request(ctx, http.MethodGet, url, headers, hint)
+}
+
+func (r *autoAuthConnector) Head(ctx context.Context, url *url.URL, headers map[string]string, hint string) (*http.Response, error) {
+	return r.Request(ctx, http.MethodHead, url, headers, hint)
+}
+
+func (r *autoAuthConnector) GetStatistics() Statistics {
+	return r.stat
+}
+
+func (r *autoAuthConnector) InvalidateTokens() {
+	r.basic.InvalidateTokens()
+	r.token.InvalidateTokens()
+}
+
+func (r *autoAuthConnector) AuthorizeScopes(ctx context.Context, registryUrl *url.URL, scopes ...string) (auth.Token, error) {
+	scheme, err := r.schemeFor(ctx, registryUrl)
+	if err != nil || scheme != authSchemeBearer {
+		return nil, err
+	}
+
+	return r.token.AuthorizeScopes(ctx, registryUrl, scopes...)
+}
+
+func (r *autoAuthConnector) Request(
+	ctx context.Context,
+	method string,
+	url *url.URL,
+	headers map[string]string,
+	hint string,
+) (response *http.Response, err error) {
+	if withoutCredentials(ctx) {
+		return r.token.Request(ctx, method, url, headers, hint)
+	}
+
+	scheme, err := r.schemeFor(ctx, url)
+	if err != nil {
+		return
+	}
+
+	response, err = r.connectorFor(scheme).Request(ctx, method, url, headers, hint)
+
+	var detected authScheme
+	var ok bool
+
+	var challengeErr *ChallengeError
+	if errors.As(err, &challengeErr) {
+		detected, ok = preferredScheme(challengeErr.Schemes)
+	} else if err == nil && response.StatusCode == http.StatusUnauthorized {
+		detected, ok = preferredScheme(auth.ChallengeSchemes(response.Header.Values("www-authenticate")))
+	}
+
+	if !ok || r.connectorFor(detected) == r.connectorFor(scheme) {
+		return
+	}
+
+	if response != nil {
+		response.Body.Close()
+	}
+
+	r.mutex.Lock()
+	r.overrides[overrideKey(ctx, url)] = detected
+	r.mutex.Unlock()
+
+	return r.connectorFor(detected).Request(ctx, method, url, headers, hint)
+}
+
+func (r *autoAuthConnector) connectorFor(scheme authScheme) Connector {
+	if scheme == authSchemeBasic {
+		return r.basic
+	}
+
+	// The token connector only authenticates once challenged, so it also
+	// serves anonymous registries.
+	return r.token
+}
+
+func (r *autoAuthConnector) schemeFor(ctx context.Context, url *url.URL) (scheme authScheme, err error) {
+	r.mutex.RLock()
+	scheme, ok := r.overrides[overrideKey(ctx, url)]
+	if !ok {
+		scheme, ok = r.hosts[url.Host]
+	}
+	r.mutex.RUnlock()
+
+	if ok {
+		return
+	}
+
+	scheme, err = r.ping(ctx, url)
+	if err != nil {
+		return
+	}
+
+	r.mutex.Lock()
+	r.hosts[url.Host] = scheme
+	r.mutex.Unlock()
+
+	return
+}
+
+func (r *autoAuthConnector) ping(ctx context.Context, registryUrl *url.URL) (scheme authScheme, err error) {
+	ping, err := r.token.ping(ctx, registryUrl)
+	if err != nil {
+		return
+	}
+
+	if !ping.unauthorized {
+		return authSchemeAnonymous, nil
+	}
+
+	if detected, ok := preferredScheme(auth.ChallengeSchemes(ping.challenges)); ok {
+		return detected, nil
+	}
+
+	return authSchemeBearer, nil
+}
+
+func preferredScheme(schemes []string) (scheme authScheme, ok bool) {
+	for _, offered := range schemes {
+		switch {
+		case strings.EqualFold(offered, "Bearer"):
+			return authSchemeBearer, true
+		case strings.EqualFold(offered, "Basic"):
+			scheme, ok = authSchemeBasic, true
+		}
+	}
+
+	return
+}
+
+func overrideKey(ctx context.Context, url *url.URL) string {
+	if info, ok := RequestInfoFromContext(ctx); ok && info.Repository != "" {
+		return url.Host + "/" + info.Repository
+	}
+
+	return url.Host + url.Path
+}
+
+func NewAutoAuthConnector(cfg Config) Connector {
+	limiter := newLimiter(cfg)
+	stat := newStatistics(limiter)
+
+	return &autoAuthConnector{
+		basic:     newBasicAuthConnector(cfg, limiter, stat),
+		token:     newTokenAuthConnector(cfg, limiter, stat),
+		stat:      stat,
+		hosts:     make(map[string]authScheme),
+		overrides: make(map[string]authScheme),
+	}
+}
@@ -5,12 +5,13 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 )
 
 type basicAuthConnector struct {
 	cfg        Config
 	httpClient *http.Client
-	semaphore  semaphore
+	limiter    limiter
 	stat       *statistics
 }
 
@@ -37,23 +38,30 @@ func (r *basicAuthConnector) Request(
 	headers map[string]string,
 	hint string,
 ) (response *http.Response, err error) {
-	r.semaphore.Lock()
-	defer r.semaphore.Unlock()
+	r.limiter.Lock()
+	defer r.limiter.Unlock()
+
+	start := time.Now()
+	defer func() {
+		r.limiter.Report(time.Since(start), response, err)
+	}()
 
 	r.stat.Request()
 
-	request, err := http.NewRequest(method, url.String(), strings.NewReader(""))
+	request, err := http.NewRequestWithContext(requestContext(ctx, hint), method, url.String(), strings.NewReader(""))
 	if err != nil {
 		return
 	}
 
-	if ctx != nil && ctx != context.TODO() && ctx != context.Background() {
-		request = request.WithContext(ctx)
-	}
+	if !withoutCredentials(ctx) {
+		credentials, err := resolveCredentials(r.cfg.CredentialProvider(), request)
+		if err != nil {
+			return nil, err
+		}
 
-	credentials := r.cfg.Credentials()
-	if credentials.Password() != "" || credentials.User() != "" {
-		request.SetBasicAuth(credentials.User(), credentials.Password())
+		if credentials.Password != "" || credentials.Username != "" {
+			request.SetBasicAuth(credentials.Username, credentials.Password)
+		}
 	}
 
 	for header, value := range headers {
@@ -66,15 +74,30 @@ func (r *basicAuthConnector) Request(
 	return
 }
 
+// InvalidateTokens is a no-op; basic auth sends the credentials with every
+// request.
+func (r *basicAuthConnector) InvalidateTokens() {
+}
+
 func NewBasicAuthConnector(cfg Config) Connector {
+	limiter := newLimiter(cfg)
+	return newBasicAuthConnector(cfg, limiter, newStatistics(limiter))
+}
+
+func newBasicAuthConnector(cfg Config, limiter limiter, stat *statistics) *basicAuthConnector {
 	c := &basicAuthConnector{
 		cfg:        cfg,
 		httpClient: cfg.HttpClient(),
-		semaphore:  newSemaphore(cfg.MaxConcurrentRequests()),
-		stat:       new(statistics),
+		limiter:    limiter,
+		stat:       stat,
 	}
 	if c.httpClient == nil {
 		c.httpClient = createHttpClient(cfg)
 	}
+	c.httpClient = wrapHttpClient(
+		withRedirectPolicy(instrumentHttpClient(c.httpClient, c.stat, classifyRegistryEndpoint), c.stat),
+		cfg,
+		OperationUnknown,
+	)
 	return c
 }
@@ -0,0 +1,50 @@
+package connector
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kspeeder/docker-registry/lib/auth"
+)
+
+// ChallengeError is returned by the token auth connector when the registry
+// answers with a challenge it cannot satisfy with a bearer token.
+type ChallengeError struct {
+	Schemes []string
+	Err     error
+}
+
+func (e *ChallengeError) Error() string {
+	switch {
+	case len(e.Schemes) == 0:
+		return "registry rejected the request without an authentication challenge"
+
+	case !e.offers("Bearer"):
+		return fmt.Sprintf("registry requested %s authentication instead of a bearer token; use basic auth or automatic auth detection",
+			strings.Join(e.Schemes, ", "))
+
+	default:
+		return fmt.Sprintf("invalid bearer challenge: %v", e.Err)
+	}
+}
+
+func (e *ChallengeError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ChallengeError) offers(scheme string) bool {
+	for _, offered := range e.Schemes {
+		if strings.EqualFold(offered, scheme) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func newChallengeError(headers []string, err error) error {
+	return &ChallengeError{
+		Schemes: auth.ChallengeSchemes(headers),
+		Err:     err,
+	}
+}
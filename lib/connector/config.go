@@ -8,10 +8,17 @@ import (
 
 type Config interface {
 	MaxConcurrentRequests() uint
-	Credentials() auth.RegistryCredentials
+	MinConcurrentRequests() uint
+	AdaptiveConcurrency() bool
+	CredentialProvider() auth.CredentialProvider
 	AllowInsecure() bool
 	UserAgent() string
 	HttpClient() *http.Client
 	FastChannelTokenProvider() auth.FastChannelTokenProvider
 	FastChannel() bool
+	OAuth2Options() auth.OAuth2Options
+	TokenCacheFile() string
+	AnonymousFallback() bool
+	AnonymousFallbackHandler() auth.AnonymousFallbackHandler
+	Middlewares() []Middleware
 }
@@ -13,3 +13,10 @@ type Connector interface {
 	Head(ctx context.Context, url *url.URL, headers map[string]string, hint string) (*http.Response, error)
 	GetStatistics() Statistics
 }
+
+// TokenInvalidator is implemented by connectors caching auth tokens. It is not
+// part of Connector, so existing implementations keep working.
+type TokenInvalidator interface {
+	// InvalidateTokens drops cached auth tokens.
+	InvalidateTokens()
+}
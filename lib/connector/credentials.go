@@ -0,0 +1,49 @@
+package connector
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/kspeeder/docker-registry/lib/auth"
+)
+
+func requestRepository(request *http.Request) string {
+	if info, ok := RequestInfoFromContext(request.Context()); ok && info.Repository != "" {
+		return info.Repository
+	}
+
+	return repositoryFromPath(request.URL.Path)
+}
+
+// credentialContext tells the authenticator which registry and repository to
+// resolve credentials for.
+func credentialContext(request *http.Request) context.Context {
+	return auth.WithCredentialTarget(request.Context(), request.URL.Host, requestRepository(request))
+}
+
+func resolveCredentials(provider auth.CredentialProvider, request *http.Request) (credentials auth.Credentials, err error) {
+	if provider == nil {
+		return
+	}
+
+	credentials, _, err = provider.Credentials(request.Context(), request.URL.Host, requestRepository(request))
+
+	return
+}
+
+type withoutCredentialsKey struct{}
+
+// WithoutCredentials makes requests with the returned context go out without
+// credentials or tokens, e.g. to presigned storage URLs.
+func WithoutCredentials(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	return context.WithValue(ctx, withoutCredentialsKey{}, true)
+}
+
+func withoutCredentials(ctx context.Context) bool {
+	without, _ := ctx.Value(withoutCredentialsKey{}).(bool)
+	return without
+}
@@ -0,0 +1,25 @@
+package connector
+
+import "net/http"
+
+type headerMiddleware struct {
+	headers map[string]string
+}
+
+func (m *headerMiddleware) Before(request *http.Request, info RequestInfo) *http.Request {
+	for header, value := range m.headers {
+		request.Header.Set(header, value)
+	}
+
+	return request
+}
+
+func (m *headerMiddleware) After(request *http.Request, info RequestInfo, response *http.Response, err error) {
+}
+
+// NewHeaderMiddleware sets fixed headers on every outgoing request.
+func NewHeaderMiddleware(headers map[string]string) Middleware {
+	return &headerMiddleware{
+		headers: headers,
+	}
+}
@@ -14,11 +14,9 @@ func createHttpClient(cfg Config) *http.Client {
 	}
 
 	return &http.Client{
-		Transport: &userAgentTransport{
-			userAgent: cfg.UserAgent(),
-			transport: &http.Transport{
-				Proxy:           http.ProxyFromEnvironment,
-				TLSClientConfig: tlsConfig,
-			}},
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
 	}
 }
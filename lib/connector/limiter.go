@@ -0,0 +1,21 @@
+package connector
+
+import (
+	"net/http"
+	"time"
+)
+
+type limiter interface {
+	Lock()
+	Unlock()
+	Report(latency time.Duration, response *http.Response, err error)
+	Limit() uint
+}
+
+func newLimiter(cfg Config) limiter {
+	if cfg.AdaptiveConcurrency() {
+		return newAdaptiveSemaphore(cfg.MinConcurrentRequests(), cfg.MaxConcurrentRequests())
+	}
+
+	return newSemaphore(cfg.MaxConcurrentRequests())
+}
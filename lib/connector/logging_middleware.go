@@ -0,0 +1,94 @@
+package connector
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// Query parameters carrying signatures in presigned storage URLs, which
+// requests reach by redirects or straight from the redirect cache.
+var redactedQueryParams = []string{
+	"X-Amz-Signature",
+	"X-Amz-Credential",
+	"X-Amz-Security-Token",
+	"X-Goog-Signature",
+	"X-Goog-Credential",
+	"Signature",
+	"sig",
+}
+
+type loggingMiddleware struct {
+	logger *log.Logger
+}
+
+type requestStartKey struct{}
+
+func (m *loggingMiddleware) Before(request *http.Request, info RequestInfo) *http.Request {
+	m.logger.Printf("%s %s operation=%q repository=%q hint=%q headers=%v",
+		request.Method, redactUrl(request.URL), info.Operation, info.Repository, info.Hint, redactHeaders(request.Header))
+
+	return request.WithContext(context.WithValue(request.Context(), requestStartKey{}, time.Now()))
+}
+
+func (m *loggingMiddleware) After(request *http.Request, info RequestInfo, response *http.Response, err error) {
+	var elapsed time.Duration
+	if start, ok := request.Context().Value(requestStartKey{}).(time.Time); ok {
+		elapsed = time.Since(start)
+	}
+
+	if err != nil {
+		m.logger.Printf("%s %s operation=%q failed after %v: %v", request.Method, redactUrl(request.URL), info.Operation, elapsed, err)
+		return
+	}
+
+	m.logger.Printf("%s %s operation=%q status=%d after %v headers=%v",
+		request.Method, redactUrl(request.URL), info.Operation, response.StatusCode, elapsed, redactHeaders(response.Header))
+}
+
+func redactHeaders(headers http.Header) http.Header {
+	redacted := headers.Clone()
+
+	for _, header := range redactedHeaders {
+		if redacted.Get(header) != "" {
+			redacted.Set(header, "<redacted>")
+		}
+	}
+
+	return redacted
+}
+
+func redactUrl(requestUrl *url.URL) string {
+	redacted := *requestUrl
+	redacted.User = nil
+
+	query := redacted.Query()
+	changed := false
+	for _, name := range redactedQueryParams {
+		if _, ok := query[name]; ok {
+			query.Set(name, "<redacted>")
+			changed = true
+		}
+	}
+	if changed {
+		redacted.RawQuery = query.Encode()
+	}
+
+	return redacted.String()
+}
+
+// NewLoggingMiddleware logs every request and response, with credentials
+// redacted. A nil logger logs through the standard logger.
+func NewLoggingMiddleware(logger *log.Logger) Middleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	return &loggingMiddleware{
+		logger: logger,
+	}
+}
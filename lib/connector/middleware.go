@@ -0,0 +1,80 @@
+package connector
+
+import (
+	"net/http"
+)
+
+// Middleware hooks into every request sent to the registry or the auth server.
+// Before may return a modified request (e.g. with extra headers or a derived
+// context); the request it returns is the one passed to After.
+type Middleware interface {
+	Before(request *http.Request, info RequestInfo) *http.Request
+	After(request *http.Request, info RequestInfo, response *http.Response, err error)
+}
+
+type middlewareTransport struct {
+	middlewares []Middleware
+	operation   Operation
+	transport   http.RoundTripper
+}
+
+func (t *middlewareTransport) RoundTrip(req *http.Request) (response *http.Response, err error) {
+	info, _ := RequestInfoFromContext(req.Context())
+	if t.operation != OperationUnknown {
+		info.Operation = t.operation
+	}
+
+	req = cloneRequest(req) // per RoundTrip contract
+	for _, middleware := range t.middlewares {
+		req = middleware.Before(req, info)
+	}
+
+	response, err = t.transport.RoundTrip(req)
+
+	for i := len(t.middlewares) - 1; i >= 0; i-- {
+		t.middlewares[i].After(req, info, response, err)
+	}
+
+	return
+}
+
+func cloneRequest(r *http.Request) *http.Request {
+	// shallow copy of the struct
+	r2 := new(http.Request)
+	*r2 = *r
+	// deep copy of the Header
+	r2.Header = make(http.Header, len(r.Header))
+	for k, s := range r.Header {
+		r2.Header[k] = append([]string(nil), s...)
+	}
+	return r2
+}
+
+func wrapHttpClient(client *http.Client, cfg Config, operation Operation) *http.Client {
+	// The user agent is only set on clients created by the connector; a client
+	// from the config is sent as is, apart from the configured middlewares.
+	middlewares := cfg.Middlewares()
+	if userAgent := cfg.UserAgent(); userAgent != "" && cfg.HttpClient() == nil {
+		middlewares = append([]Middleware{NewHeaderMiddleware(map[string]string{
+			"User-Agent": userAgent,
+		})}, middlewares...)
+	}
+
+	if len(middlewares) == 0 {
+		return client
+	}
+
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	wrapped := *client
+	wrapped.Transport = &middlewareTransport{
+		middlewares: middlewares,
+		operation:   operation,
+		transport:   transport,
+	}
+
+	return &wrapped
+}
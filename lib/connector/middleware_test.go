@@ -0,0 +1,141 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type recordingMiddleware struct {
+	name  string
+	calls *[]string
+	infos *[]RequestInfo
+}
+
+type middlewareNameKey struct{}
+
+func (m *recordingMiddleware) Before(request *http.Request, info RequestInfo) *http.Request {
+	*m.calls = append(*m.calls, "before "+m.name)
+	*m.infos = append(*m.infos, info)
+
+	request.Header.Add("X-Middleware", m.name)
+	return request.WithContext(context.WithValue(request.Context(), middlewareNameKey{}, m.name))
+}
+
+func (m *recordingMiddleware) After(request *http.Request, info RequestInfo, response *http.Response, err error) {
+	name, _ := request.Context().Value(middlewareNameKey{}).(string)
+	*m.calls = append(*m.calls, fmt.Sprintf("after %s (request of %s, status %d)", m.name, name, response.StatusCode))
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header["X-Middleware"]
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	var calls []string
+	var infos []RequestInfo
+	transport := &middlewareTransport{
+		middlewares: []Middleware{
+			&recordingMiddleware{name: "a", calls: &calls, infos: &infos},
+			&recordingMiddleware{name: "b", calls: &calls, infos: &infos},
+		},
+		transport: http.DefaultTransport,
+	}
+
+	request, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	request = request.WithContext(WithRequestInfo(context.Background(), RequestInfo{
+		Operation:  OperationListTags,
+		Repository: "foo",
+		Hint:       "tags",
+	}))
+
+	response, err := transport.RoundTrip(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	expected := []string{
+		"before a",
+		"before b",
+		"after b (request of b, status 204)",
+		"after a (request of b, status 204)",
+	}
+	if strings.Join(calls, "|") != strings.Join(expected, "|") {
+		t.Fatalf("expected calls %v, got %v", expected, calls)
+	}
+
+	if strings.Join(received, ",") != "a,b" {
+		t.Fatalf("expected the headers of both middlewares in order, got %v", received)
+	}
+
+	if request.Header.Get("X-Middleware") != "" {
+		t.Fatal("middlewares must not modify the caller's request")
+	}
+
+	for _, info := range infos {
+		if info.Operation != OperationListTags || info.Repository != "foo" || info.Hint != "tags" {
+			t.Fatalf("unexpected request info %+v", info)
+		}
+	}
+}
+
+func TestMiddlewareOperationOverride(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	var calls []string
+	var infos []RequestInfo
+	transport := &middlewareTransport{
+		middlewares: []Middleware{&recordingMiddleware{name: "a", calls: &calls, infos: &infos}},
+		operation:   OperationTokenFetch,
+		transport:   http.DefaultTransport,
+	}
+
+	request, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	request = request.WithContext(WithRequestInfo(context.Background(), RequestInfo{
+		Operation:  OperationGetBlob,
+		Repository: "foo",
+	}))
+
+	response, err := transport.RoundTrip(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	if len(infos) != 1 || infos[0].Operation != OperationTokenFetch || infos[0].Repository != "foo" {
+		t.Fatalf("expected the token fetch operation for repository foo, got %+v", infos)
+	}
+}
+
+func TestLoggingMiddlewareRedactsSignatures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	var output strings.Builder
+	transport := &middlewareTransport{
+		middlewares: []Middleware{NewLoggingMiddleware(log.New(&output, "", 0))},
+		transport:   http.DefaultTransport,
+	}
+
+	request, _ := http.NewRequest(http.MethodGet, server.URL+"/blobs/abc?X-Amz-Signature=secret&X-Goog-Signature=secret&sig=secret&X-Amz-Expires=60", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+
+	response, err := transport.RoundTrip(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	if strings.Contains(output.String(), "secret") || !strings.Contains(output.String(), "X-Amz-Expires=60") {
+		t.Fatalf("expected signatures to be redacted, got %s", output.String())
+	}
+}
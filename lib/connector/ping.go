@@ -0,0 +1,71 @@
+package connector
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// pingResult is the answer of a registry to GET /v2/.
+type pingResult struct {
+	unauthorized bool
+	challenges   []string
+}
+
+// pingCache remembers the ping result per host, so scheme detection and scope
+// authorization share one round trip.
+type pingCache struct {
+	results map[string]pingResult
+	mutex   sync.Mutex
+}
+
+func (c *pingCache) Invalidate() {
+	c.mutex.Lock()
+	c.results = make(map[string]pingResult)
+	c.mutex.Unlock()
+}
+
+func newPingCache() *pingCache {
+	return &pingCache{
+		results: make(map[string]pingResult),
+	}
+}
+
+func (r *tokenAuthConnector) ping(ctx context.Context, registryUrl *url.URL) (result pingResult, err error) {
+	r.pings.mutex.Lock()
+	result, cached := r.pings.results[registryUrl.Host]
+	r.pings.mutex.Unlock()
+
+	if cached {
+		return
+	}
+
+	pingUrl := url.URL{
+		Scheme: registryUrl.Scheme,
+		Host:   registryUrl.Host,
+		Path:   "/v2/",
+	}
+
+	request, err := http.NewRequestWithContext(requestContext(ctx, ""), http.MethodGet, pingUrl.String(), nil)
+	if err != nil {
+		return
+	}
+
+	response, err := r.httpClient.Do(request)
+	if err != nil {
+		return
+	}
+	response.Body.Close()
+
+	result = pingResult{
+		unauthorized: response.StatusCode == http.StatusUnauthorized,
+		challenges:   response.Header.Values("www-authenticate"),
+	}
+
+	r.pings.mutex.Lock()
+	r.pings.results[registryUrl.Host] = result
+	r.pings.mutex.Unlock()
+
+	return
+}
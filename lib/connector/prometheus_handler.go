@@ -0,0 +1,135 @@
+package connector
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const prometheusNamespace = "docker_registry"
+
+type prometheusHandler struct {
+	stat Statistics
+}
+
+// NewPrometheusHandler exposes the connector statistics in the Prometheus
+// text exposition format.
+func NewPrometheusHandler(stat Statistics) http.Handler {
+	return &prometheusHandler{
+		stat: stat,
+	}
+}
+
+func (h *prometheusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	buffered := bufio.NewWriter(w)
+	writePrometheusMetrics(buffered, h.stat.Snapshot())
+	buffered.Flush()
+}
+
+func writePrometheusMetrics(w io.Writer, s StatisticsSnapshot) {
+	writeMetricHeader(w, "api_requests_total", "counter", "Requests issued through the connector.")
+	writeSample(w, "api_requests_total", "", float64(s.Requests))
+
+	writeMetricHeader(w, "token_cache_hits_total", "counter", "Token cache hits.")
+	writeSample(w, "token_cache_hits_total", labels("level", "api"), float64(s.TokenCacheHitsAtApiLevel))
+	writeSample(w, "token_cache_hits_total", labels("level", "auth"), float64(s.TokenCacheHitsAtAuthLevel))
+
+	writeMetricHeader(w, "token_cache_misses_total", "counter", "Token cache misses.")
+	writeSample(w, "token_cache_misses_total", labels("level", "api"), float64(s.TokenCacheMissesAtApiLevel))
+	writeSample(w, "token_cache_misses_total", labels("level", "auth"), float64(s.TokenCacheMissesAtAuthLevel))
+
+	writeMetricHeader(w, "token_cache_fails_total", "counter", "Cached tokens rejected by the registry.")
+	writeSample(w, "token_cache_fails_total", labels("level", "api"), float64(s.TokenCacheFailsAtApiLevel))
+	writeSample(w, "token_cache_fails_total", labels("level", "auth"), float64(s.TokenCacheFailsAtAuthLevel))
+
+	writeMetricHeader(w, "retries_total", "counter", "Requests repeated after an authentication challenge.")
+	writeSample(w, "retries_total", "", float64(s.Retries))
+
+	writeMetricHeader(w, "auth_round_trips_total", "counter", "Round trips to the auth server.")
+	writeSample(w, "auth_round_trips_total", "", float64(s.AuthRoundTrips))
+
+	writeMetricHeader(w, "anonymous_fallbacks_total", "counter", "Pull tokens fetched anonymously after credentials were rejected.")
+	writeSample(w, "anonymous_fallbacks_total", "", float64(s.AnonymousFallbacks))
+
+	writeMetricHeader(w, "redirects_total", "counter", "Redirects followed, e.g. to blob storage.")
+	writeSample(w, "redirects_total", "", float64(s.Redirects))
+
+	writeMetricHeader(w, "downloaded_bytes_total", "counter", "Response body bytes received.")
+	writeSample(w, "downloaded_bytes_total", "", float64(s.BytesDownloaded))
+
+	writeMetricHeader(w, "concurrency_limit", "gauge", "Current concurrent request limit.")
+	writeSample(w, "concurrency_limit", "", float64(s.ConcurrencyLimit))
+
+	writeMetricHeader(w, "http_requests_total", "counter", "HTTP round trips by method, endpoint class and status code.")
+	for _, series := range s.Series {
+		writeSample(w, "http_requests_total", seriesLabels(series), float64(series.Requests))
+	}
+
+	writeMetricHeader(w, "http_response_bytes_total", "counter", "Response body bytes by method, endpoint class and status code.")
+	for _, series := range s.Series {
+		writeSample(w, "http_response_bytes_total", seriesLabels(series), float64(series.BytesDownloaded))
+	}
+
+	writeMetricHeader(w, "http_request_duration_seconds", "histogram", "Time until the response body was consumed.")
+	for _, series := range s.Series {
+		writeHistogram(w, "http_request_duration_seconds", seriesLabels(series), series.Latency)
+	}
+
+	writeMetricHeader(w, "http_time_to_first_byte_seconds", "histogram", "Time until response headers were received.")
+	for _, series := range s.Series {
+		writeHistogram(w, "http_time_to_first_byte_seconds", seriesLabels(series), series.TimeToFirstByte)
+	}
+}
+
+func writeMetricHeader(w io.Writer, name, metricType, help string) {
+	fmt.Fprintf(w, "# HELP %s_%s %s\n", prometheusNamespace, name, help)
+	fmt.Fprintf(w, "# TYPE %s_%s %s\n", prometheusNamespace, name, metricType)
+}
+
+func writeSample(w io.Writer, name, labels string, value float64) {
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+
+	fmt.Fprintf(w, "%s_%s%s %s\n", prometheusNamespace, name, labels, formatFloat(value))
+}
+
+func writeHistogram(w io.Writer, name, seriesLabels string, h HistogramSnapshot) {
+	for i, bound := range h.Buckets {
+		writeSample(w, name+"_bucket", seriesLabels+","+labels("le", formatFloat(bound)), float64(h.Counts[i]))
+	}
+
+	writeSample(w, name+"_bucket", seriesLabels+","+labels("le", "+Inf"), float64(h.Count))
+	writeSample(w, name+"_sum", seriesLabels, h.Sum)
+	writeSample(w, name+"_count", seriesLabels, float64(h.Count))
+}
+
+func seriesLabels(s SeriesSnapshot) string {
+	code := "error"
+	if s.StatusCode != 0 {
+		code = strconv.Itoa(s.StatusCode)
+	}
+
+	return labels("method", s.Method, "endpoint", string(s.Endpoint), "code", code)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labels(pairs ...string) string {
+	rendered := make([]string, 0, len(pairs)/2)
+
+	for i := 0; i+1 < len(pairs); i += 2 {
+		rendered = append(rendered, fmt.Sprintf(`%s="%s"`, pairs[i], labelValueEscaper.Replace(pairs[i+1])))
+	}
+
+	return strings.Join(rendered, ",")
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,49 @@
+package connector
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPrometheusExposition(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "0123456789")
+	}))
+	defer server.Close()
+
+	stat := newStatistics(newSemaphore(3))
+	client := instrumentHttpClient(server.Client(), stat, classifyRegistryEndpoint)
+
+	response, err := client.Get(server.URL + "/v2/foo/bar/blobs/sha256:abcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+
+	recorder := httptest.NewRecorder()
+	NewPrometheusHandler(stat).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+
+	for _, expected := range []string{
+		`docker_registry_http_requests_total{method="GET",endpoint="blob",code="200"} 1`,
+		`docker_registry_http_response_bytes_total{method="GET",endpoint="blob",code="200"} 10`,
+		`docker_registry_http_request_duration_seconds_count{method="GET",endpoint="blob",code="200"} 1`,
+		`docker_registry_http_time_to_first_byte_seconds_bucket{method="GET",endpoint="blob",code="200",le="+Inf"} 1`,
+		`docker_registry_downloaded_bytes_total 10`,
+		`docker_registry_concurrency_limit 3`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("expected %q in exposition, got\n%s", expected, body)
+		}
+	}
+
+	stat.Reset()
+
+	if snapshot := stat.Snapshot(); len(snapshot.Series) != 0 || snapshot.BytesDownloaded != 0 {
+		t.Fatalf("reset did not clear statistics: %+v", snapshot)
+	}
+}
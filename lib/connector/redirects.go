@@ -0,0 +1,86 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+const maxRedirects = 10
+
+type noRedirectsKey struct{}
+
+// WithoutRedirects makes requests with the returned context return redirect
+// responses instead of following them, e.g. to learn the storage URL of a
+// blob.
+func WithoutRedirects(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	return context.WithValue(ctx, noRedirectsKey{}, true)
+}
+
+// FinalURL returns the URL a response was served from after following all
+// redirects.
+func FinalURL(response *http.Response) *url.URL {
+	if response == nil || response.Request == nil {
+		return nil
+	}
+
+	return response.Request.URL
+}
+
+// RedirectLocation returns the resolved target of a redirect response, or nil.
+func RedirectLocation(response *http.Response) *url.URL {
+	switch response.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return nil
+	}
+
+	location, err := FinalURL(response).Parse(response.Header.Get("Location"))
+	if err != nil || response.Header.Get("Location") == "" {
+		return nil
+	}
+
+	return location
+}
+
+// withRedirectPolicy only sends credentials to the scheme and host of the
+// original request when following redirects, and counts them.
+func withRedirectPolicy(client *http.Client, stat *statistics) *http.Client {
+	checkRedirect := client.CheckRedirect
+
+	wrapped := *client
+	wrapped.CheckRedirect = func(request *http.Request, via []*http.Request) error {
+		if noRedirects, _ := request.Context().Value(noRedirectsKey{}).(bool); noRedirects {
+			return http.ErrUseLastResponse
+		}
+
+		if len(via) >= maxRedirects {
+			return errors.New("stopped after 10 redirects")
+		}
+
+		original := via[0].URL
+		if request.URL.Host != original.Host || request.URL.Scheme != original.Scheme {
+			request.Header.Del("Authorization")
+			request.Header.Del("Cookie")
+		}
+
+		if checkRedirect != nil {
+			if err := checkRedirect(request, via); err != nil {
+				return err
+			}
+		}
+
+		// Only redirects that are followed are counted.
+		stat.Redirect()
+
+		return nil
+	}
+
+	return &wrapped
+}
@@ -0,0 +1,41 @@
+package connector
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectsCountedWhenFollowed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/from" {
+			http.Redirect(w, r, "/to", http.StatusTemporaryRedirect)
+		}
+	}))
+	defer server.Close()
+
+	rejected := errors.New("redirect rejected")
+	for _, test := range []struct {
+		checkRedirect func(*http.Request, []*http.Request) error
+		redirects     uint
+	}{
+		{nil, 1},
+		{func(*http.Request, []*http.Request) error { return rejected }, 0},
+	} {
+		client := *server.Client()
+		client.CheckRedirect = test.checkRedirect
+
+		stat := newStatistics(newSemaphore(1))
+		response, err := withRedirectPolicy(&client, stat).Get(server.URL + "/from")
+		if err == nil {
+			response.Body.Close()
+		} else if !errors.Is(err, rejected) {
+			t.Fatal(err)
+		}
+
+		if stat.Redirects() != test.redirects {
+			t.Fatalf("expected %d counted redirects, got %d", test.redirects, stat.Redirects())
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package connector
+
+import "context"
+
+type Operation string
+
+const (
+	OperationUnknown          Operation = ""
+	OperationListRepositories Operation = "list-repositories"
+	OperationListTags         Operation = "list-tags"
+	OperationGetManifest      Operation = "get-manifest"
+	OperationHeadManifest     Operation = "head-manifest"
+	OperationDeleteTag        Operation = "delete-tag"
+	OperationGetBlob          Operation = "get-blob"
+	OperationHeadBlob         Operation = "head-blob"
+	OperationRangeBlob        Operation = "range-blob"
+	OperationTokenFetch       Operation = "token-fetch"
+	OperationCapabilities     Operation = "capabilities"
+	OperationCheckAccess      Operation = "check-access"
+)
+
+// RequestInfo describes the logical operation an HTTP request belongs to. It
+// travels in the request context and is handed to middlewares.
+type RequestInfo struct {
+	Operation  Operation
+	Repository string
+	Hint       string
+	// Scopes are requested in addition to those the operation needs.
+	Scopes []string
+}
+
+type requestInfoKey struct{}
+
+func WithRequestInfo(ctx context.Context, info RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+func RequestInfoFromContext(ctx context.Context) (info RequestInfo, ok bool) {
+	if ctx == nil {
+		return
+	}
+
+	info, ok = ctx.Value(requestInfoKey{}).(RequestInfo)
+	return
+}
+
+func requestContext(ctx context.Context, hint string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	info, _ := RequestInfoFromContext(ctx)
+	if info.Hint == "" && hint != "" {
+		info.Hint = hint
+		ctx = WithRequestInfo(ctx, info)
+	}
+
+	return ctx
+}
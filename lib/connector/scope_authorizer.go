@@ -0,0 +1,17 @@
+package connector
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/kspeeder/docker-registry/lib/auth"
+)
+
+// ScopeAuthorizer is implemented by connectors that can obtain a token for
+// given scopes up front.
+type ScopeAuthorizer interface {
+	// AuthorizeScopes returns a token for the scopes, or nil if the registry
+	// does not use bearer tokens. Auth servers may grant a subset of the
+	// requested scopes.
+	AuthorizeScopes(ctx context.Context, registryUrl *url.URL, scopes ...string) (auth.Token, error)
+}
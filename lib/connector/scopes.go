@@ -0,0 +1,91 @@
+package connector
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/kspeeder/docker-registry/lib/auth"
+)
+
+// WithScopes requests tokens that also cover the given scopes for requests
+// made with the returned context, e.g. to pull from one repository and push
+// to another with a single token.
+func WithScopes(ctx context.Context, scopes ...string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	info, _ := RequestInfoFromContext(ctx)
+	info.Scopes = append(append([]string(nil), info.Scopes...), scopes...)
+
+	return WithRequestInfo(ctx, info)
+}
+
+// scopedHint adds the scopes requested through the context to a scope set
+// hint, so tokens for different scope sets are cached apart.
+func scopedHint(ctx context.Context, hint string) string {
+	info, ok := RequestInfoFromContext(ctx)
+	if hint == "" || !ok || len(info.Scopes) == 0 {
+		return hint
+	}
+
+	return auth.JoinScopes(append(strings.Fields(hint), info.Scopes...)...)
+}
+
+// hintScopes returns the scopes of a scope set hint.
+func hintScopes(hint string) (scopes []string) {
+	for _, field := range strings.Fields(hint) {
+		if _, err := auth.ParseScope(field); err == nil {
+			scopes = append(scopes, field)
+		}
+	}
+
+	return
+}
+
+// requestScope derives the scope a request needs from its operation, for
+// requests without scope hint.
+func requestScope(request *http.Request) string {
+	info, _ := RequestInfoFromContext(request.Context())
+	repository := requestRepository(request)
+
+	if info.Operation == OperationListRepositories || repository == "" && request.URL.Path == "/v2/_catalog" {
+		return auth.CatalogScope().String()
+	}
+
+	if repository == "" {
+		return ""
+	}
+
+	switch {
+	case info.Operation == OperationDeleteTag || request.Method == http.MethodDelete:
+		return auth.RepositoryScope(repository, auth.ActionDelete).String()
+	case request.Method == http.MethodGet || request.Method == http.MethodHead:
+		return auth.RepositoryScope(repository, auth.ActionPull).String()
+	default:
+		return auth.RepositoryScope(repository, auth.ActionPull, auth.ActionPush).String()
+	}
+}
+
+var repositoryPathMarkers = []string{"/manifests/", "/blobs/", "/tags/", "/referrers/"}
+
+func repositoryFromPath(path string) string {
+	if !strings.HasPrefix(path, "/v2/") {
+		return ""
+	}
+	path = path[len("/v2"):]
+
+	end := -1
+	for _, marker := range repositoryPathMarkers {
+		if index := strings.LastIndex(path, marker); index > end {
+			end = index
+		}
+	}
+
+	if end <= 0 {
+		return ""
+	}
+
+	return path[1:end]
+}
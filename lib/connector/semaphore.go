@@ -1,5 +1,10 @@
 package connector
 
+import (
+	"net/http"
+	"time"
+)
+
 type semaphore chan int
 
 func (s semaphore) Lock() {
@@ -10,6 +15,12 @@ func (s semaphore) Unlock() {
 	_ = <-s
 }
 
+func (s semaphore) Report(latency time.Duration, response *http.Response, err error) {}
+
+func (s semaphore) Limit() uint {
+	return uint(cap(s))
+}
+
 func newSemaphore(limit uint) semaphore {
 	if limit == 0 {
 		limit = 1
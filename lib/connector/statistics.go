@@ -2,6 +2,7 @@ package connector
 
 import (
 	"sync"
+	"time"
 )
 
 type Statistics interface {
@@ -12,6 +13,14 @@ type Statistics interface {
 	TokenCacheHitsAtAuthLevel() uint
 	TokenCacheMissesAtAuthLevel() uint
 	TokenCacheFailsAtAuthLevel() uint
+	ConcurrencyLimit() uint
+	Retries() uint
+	AuthRoundTrips() uint
+	AnonymousFallbacks() uint
+	Redirects() uint
+	BytesDownloaded() uint64
+	Snapshot() StatisticsSnapshot
+	Reset()
 }
 
 type statistics struct {
@@ -22,6 +31,13 @@ type statistics struct {
 	cacheHitsAtAuthLevel   uint
 	cacheMissesAtAuthLevel uint
 	cacheFailsAtAuthLevel  uint
+	retries                uint
+	authRoundTrips         uint
+	anonymousFallbacks     uint
+	redirects              uint
+	bytesDownloaded        uint64
+	series                 map[seriesKey]*series
+	limiter                limiter
 	mutex                  sync.RWMutex
 }
 
@@ -81,6 +97,97 @@ func (s *statistics) TokenCacheFailsAtAuthLevel() (r uint) {
 	return
 }
 
+func (s *statistics) ConcurrencyLimit() uint {
+	return s.limiter.Limit()
+}
+
+func (s *statistics) Retries() (r uint) {
+	s.mutex.RLock()
+	r = s.retries
+	s.mutex.RUnlock()
+
+	return
+}
+
+func (s *statistics) AuthRoundTrips() (r uint) {
+	s.mutex.RLock()
+	r = s.authRoundTrips
+	s.mutex.RUnlock()
+
+	return
+}
+
+func (s *statistics) AnonymousFallbacks() (r uint) {
+	s.mutex.RLock()
+	r = s.anonymousFallbacks
+	s.mutex.RUnlock()
+
+	return
+}
+
+func (s *statistics) Redirects() (r uint) {
+	s.mutex.RLock()
+	r = s.redirects
+	s.mutex.RUnlock()
+
+	return
+}
+
+func (s *statistics) BytesDownloaded() (r uint64) {
+	s.mutex.RLock()
+	r = s.bytesDownloaded
+	s.mutex.RUnlock()
+
+	return
+}
+
+func (s *statistics) Snapshot() (snapshot StatisticsSnapshot) {
+	s.mutex.RLock()
+	snapshot = StatisticsSnapshot{
+		Requests:                    s.requests,
+		TokenCacheHitsAtApiLevel:    s.cacheHitsAtApiLevel,
+		TokenCacheMissesAtApiLevel:  s.cacheMissesAtApiLevel,
+		TokenCacheFailsAtApiLevel:   s.cacheFailsAtApiLevel,
+		TokenCacheHitsAtAuthLevel:   s.cacheHitsAtAuthLevel,
+		TokenCacheMissesAtAuthLevel: s.cacheMissesAtAuthLevel,
+		TokenCacheFailsAtAuthLevel:  s.cacheFailsAtAuthLevel,
+		Retries:                     s.retries,
+		AuthRoundTrips:              s.authRoundTrips,
+		AnonymousFallbacks:          s.anonymousFallbacks,
+		Redirects:                   s.redirects,
+		BytesDownloaded:             s.bytesDownloaded,
+		Series:                      make([]SeriesSnapshot, 0, len(s.series)),
+	}
+
+	for key, value := range s.series {
+		snapshot.Series = append(snapshot.Series, value.snapshot(key))
+	}
+	s.mutex.RUnlock()
+
+	snapshot.ConcurrencyLimit = s.ConcurrencyLimit()
+	sortSeries(snapshot.Series)
+
+	return
+}
+
+func (s *statistics) Reset() {
+	s.mutex.Lock()
+	s.requests = 0
+	s.cacheHitsAtApiLevel = 0
+	s.cacheMissesAtApiLevel = 0
+	s.cacheFailsAtApiLevel = 0
+	s.cacheHitsAtAuthLevel = 0
+	s.cacheMissesAtAuthLevel = 0
+	s.cacheFailsAtAuthLevel = 0
+	s.retries = 0
+	s.authRoundTrips = 0
+	s.anonymousFallbacks = 0
+	s.redirects = 0
+	s.bytesDownloaded = 0
+	s.series = make(map[seriesKey]*series)
+	s.mutex.Unlock()
+}
+
 func (s *statistics) Request() {
 	s.mutex.Lock()
 	s.requests++
@@ -122,3 +229,59 @@ func (s *statistics) CacheFailAtAuthLevel() {
 	s.cacheFailsAtAuthLevel++
 	s.mutex.Unlock()
 }
+
+func (s *statistics) Retry() {
+	s.mutex.Lock()
+	s.retries++
+	s.mutex.Unlock()
+}
+
+func (s *statistics) AnonymousFallback() {
+	s.mutex.Lock()
+	s.anonymousFallbacks++
+	s.mutex.Unlock()
+}
+
+func (s *statistics) Redirect() {
+	s.mutex.Lock()
+	s.redirects++
+	s.mutex.Unlock()
+}
+
+func (s *statistics) RoundTrip(key seriesKey, timeToFirstByte time.Duration) {
+	s.mutex.Lock()
+	entry := s.seriesFor(key)
+	entry.requests++
+	entry.timeToFirstByte.Observe(timeToFirstByte)
+
+	if key.endpoint == EndpointAuth {
+		s.authRoundTrips++
+	}
+	s.mutex.Unlock()
+}
+
+func (s *statistics) RoundTripCompleted(key seriesKey, latency time.Duration, bytes uint64) {
+	s.mutex.Lock()
+	entry := s.seriesFor(key)
+	entry.latency.Observe(latency)
+	entry.bytesDownloaded += bytes
+	s.bytesDownloaded += bytes
+	s.mutex.Unlock()
+}
+
+func (s *statistics) seriesFor(key seriesKey) *series {
+	entry, ok := s.series[key]
+	if !ok {
+		entry = newSeries()
+		s.series[key] = entry
+	}
+
+	return entry
+}
+
+func newStatistics(limiter limiter) *statistics {
+	return &statistics{
+		series:  make(map[seriesKey]*series),
+		limiter: limiter,
+	}
+}
@@ -0,0 +1,152 @@
+package connector
+
+import (
+	"sort"
+	"time"
+)
+
+type EndpointClass string
+
+const (
+	EndpointCatalog  EndpointClass = "catalog"
+	EndpointTags     EndpointClass = "tags"
+	EndpointManifest EndpointClass = "manifest"
+	EndpointBlob     EndpointClass = "blob"
+	EndpointAuth     EndpointClass = "auth"
+	EndpointOther    EndpointClass = "other"
+)
+
+// Upper bounds in seconds. Blob downloads can take minutes, so the scale
+// extends well beyond typical API latencies.
+var defaultLatencyBuckets = []float64{
+	0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120,
+}
+
+type StatisticsSnapshot struct {
+	Requests                    uint
+	TokenCacheHitsAtApiLevel    uint
+	TokenCacheMissesAtApiLevel  uint
+	TokenCacheFailsAtApiLevel   uint
+	TokenCacheHitsAtAuthLevel   uint
+	TokenCacheMissesAtAuthLevel uint
+	TokenCacheFailsAtAuthLevel  uint
+	ConcurrencyLimit            uint
+	Retries                     uint
+	AuthRoundTrips              uint
+	AnonymousFallbacks          uint
+	Redirects                   uint
+	BytesDownloaded             uint64
+	Series                      []SeriesSnapshot
+}
+
+// SeriesSnapshot aggregates all HTTP exchanges sharing method, endpoint class
+// and status code. A StatusCode of zero denotes transport errors.
+type SeriesSnapshot struct {
+	Method          string
+	Endpoint        EndpointClass
+	StatusCode      int
+	Requests        uint
+	BytesDownloaded uint64
+	Latency         HistogramSnapshot
+	TimeToFirstByte HistogramSnapshot
+}
+
+// HistogramSnapshot uses Prometheus semantics: Counts[i] is the number of
+// observations less than or equal to Buckets[i].
+type HistogramSnapshot struct {
+	Buckets []float64
+	Counts  []uint64
+	Count   uint64
+	Sum     float64
+}
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func (h *histogram) Observe(d time.Duration) {
+	value := d.Seconds()
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+
+	h.count++
+	h.sum += value
+}
+
+func (h *histogram) snapshot() HistogramSnapshot {
+	s := HistogramSnapshot{
+		Buckets: append([]float64(nil), h.buckets...),
+		Counts:  make([]uint64, len(h.counts)),
+		Count:   h.count,
+		Sum:     h.sum,
+	}
+
+	var cumulative uint64
+	for i, count := range h.counts {
+		cumulative += count
+		s.Counts[i] = cumulative
+	}
+
+	return s
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+type seriesKey struct {
+	method     string
+	endpoint   EndpointClass
+	statusCode int
+}
+
+type series struct {
+	requests        uint
+	bytesDownloaded uint64
+	latency         *histogram
+	timeToFirstByte *histogram
+}
+
+func (s *series) snapshot(key seriesKey) SeriesSnapshot {
+	return SeriesSnapshot{
+		Method:          key.method,
+		Endpoint:        key.endpoint,
+		StatusCode:      key.statusCode,
+		Requests:        s.requests,
+		BytesDownloaded: s.bytesDownloaded,
+		Latency:         s.latency.snapshot(),
+		TimeToFirstByte: s.timeToFirstByte.snapshot(),
+	}
+}
+
+func newSeries() *series {
+	return &series{
+		latency:         newHistogram(defaultLatencyBuckets),
+		timeToFirstByte: newHistogram(defaultLatencyBuckets),
+	}
+}
+
+func sortSeries(s []SeriesSnapshot) {
+	sort.Slice(s, func(i, j int) bool {
+		if s[i].Method != s[j].Method {
+			return s[i].Method < s[j].Method
+		}
+
+		if s[i].Endpoint != s[j].Endpoint {
+			return s[i].Endpoint < s[j].Endpoint
+		}
+
+		return s[i].StatusCode < s[j].StatusCode
+	})
+}
@@ -0,0 +1,115 @@
+package connector
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type statisticsTransport struct {
+	stat      *statistics
+	classify  func(*http.Request) EndpointClass
+	transport http.RoundTripper
+}
+
+func (t *statisticsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	response, err := t.transport.RoundTrip(req)
+	timeToFirstByte := time.Since(start)
+
+	key := seriesKey{
+		method:   req.Method,
+		endpoint: t.classify(req),
+	}
+
+	if err != nil {
+		t.stat.RoundTrip(key, timeToFirstByte)
+		t.stat.RoundTripCompleted(key, timeToFirstByte, 0)
+
+		return response, err
+	}
+
+	key.statusCode = response.StatusCode
+	t.stat.RoundTrip(key, timeToFirstByte)
+
+	response.Body = &statisticsBody{
+		ReadCloser: response.Body,
+		stat:       t.stat,
+		key:        key,
+		start:      start,
+	}
+
+	return response, nil
+}
+
+// statisticsBody records transfer size and total latency once the body has
+// been consumed or closed, whichever comes first.
+type statisticsBody struct {
+	io.ReadCloser
+	stat  *statistics
+	key   seriesKey
+	start time.Time
+	bytes uint64
+	once  sync.Once
+}
+
+func (b *statisticsBody) Read(p []byte) (n int, err error) {
+	n, err = b.ReadCloser.Read(p)
+	b.bytes += uint64(n)
+
+	if err == io.EOF {
+		b.complete()
+	}
+
+	return
+}
+
+func (b *statisticsBody) Close() error {
+	b.complete()
+	return b.ReadCloser.Close()
+}
+
+func (b *statisticsBody) complete() {
+	b.once.Do(func() {
+		b.stat.RoundTripCompleted(b.key, time.Since(b.start), b.bytes)
+	})
+}
+
+func classifyRegistryEndpoint(req *http.Request) EndpointClass {
+	path := req.URL.Path
+
+	switch {
+	case strings.HasSuffix(path, "/_catalog"):
+		return EndpointCatalog
+	case strings.HasSuffix(path, "/tags/list"):
+		return EndpointTags
+	case strings.Contains(path, "/manifests/"):
+		return EndpointManifest
+	case strings.Contains(path, "/blobs/"):
+		return EndpointBlob
+	default:
+		return EndpointOther
+	}
+}
+
+func classifyAuthEndpoint(req *http.Request) EndpointClass {
+	return EndpointAuth
+}
+
+func instrumentHttpClient(client *http.Client, stat *statistics, classify func(*http.Request) EndpointClass) *http.Client {
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	instrumented := *client
+	instrumented.Transport = &statisticsTransport{
+		stat:      stat,
+		classify:  classify,
+		transport: transport,
+	}
+
+	return &instrumented
+}
@@ -2,12 +2,10 @@ package connector
 
 import (
 	"context"
-	"errors"
-	"fmt"
 	"net/http"
 	"net/url"
-	"regexp"
 	"strings"
+	"time"
 
 	"github.com/kspeeder/docker-registry/lib/auth"
 )
@@ -16,8 +14,9 @@ type tokenAuthConnector struct {
 	cfg           Config
 	httpClient    *http.Client
 	authenticator auth.Authenticator
-	semaphore     semaphore
+	limiter       limiter
 	tokenCache    *tokenCache
+	pings         *pingCache
 	stat          *statistics
 }
 
@@ -40,28 +39,42 @@ func (r *tokenAuthConnector) Request(
 	headers map[string]string,
 	hint string,
 ) (response *http.Response, err error) {
-	r.semaphore.Lock()
-	defer r.semaphore.Unlock()
+	r.limiter.Lock()
+	defer r.limiter.Unlock()
+
+	start := time.Now()
+	defer func() {
+		r.limiter.Report(time.Since(start), response, err)
+	}()
 
 	r.stat.Request()
 
+	hint = scopedHint(ctx, hint)
+
 	var token auth.Token
-	request, err := http.NewRequest(method, url.String(), strings.NewReader(""))
+	request, err := http.NewRequestWithContext(requestContext(ctx, hint), method, url.String(), strings.NewReader(""))
 	if err != nil {
 		return
 	}
 
-	if ctx != nil && ctx != context.TODO() && ctx != context.Background() {
-		request = request.WithContext(ctx)
-	}
-
 	for header, value := range headers {
 		request.Header.Set(header, value)
 	}
 
+	if withoutCredentials(ctx) {
+		return r.attemptRequestWithToken(request, nil)
+	}
+
+	generation := r.tokenCache.Generation()
+
 	if hint != "" {
-		if token = r.tokenCache.Get(hint); token != nil {
+		var refresh *auth.Challenge
+		if token, refresh = r.tokenCache.Get(hint); token != nil {
 			r.stat.CacheHitAtApiLevel()
+
+			if refresh != nil {
+				go r.refreshToken(context.WithoutCancel(credentialContext(request)), generation, hint, refresh)
+			}
 		} else {
 			r.stat.CacheMissAtApiLevel()
 		}
@@ -81,17 +94,30 @@ func (r *tokenAuthConnector) Request(
 		resp.Body.Close()
 	}
 
-	authenticate := getAuthenticate(request.URL.Path, resp.Header.Get("www-authenticate"))
-	challenge, err := auth.ParseChallenge(authenticate)
+	challenge, err := auth.ParseChallengeHeaders(resp.Header.Values("www-authenticate"))
 
 	if err != nil {
-		err = errors.New(err.Error() +
-			" Are you shure that you are using the correct (token) auth scheme?")
-
+		err = newChallengeError(resp.Header.Values("www-authenticate"), err)
 		return
 	}
 
-	token, err = r.authenticator.Authenticate(challenge, false)
+	if challenge.InsufficientScope() {
+		if previous := r.tokenCache.Challenge(hint); previous != nil && sameAuthority(previous, challenge) {
+			challenge, _ = previous.Widen(challenge.Scope()...)
+		}
+	}
+
+	// Ask for everything the caller needs up front; some registries also
+	// omit the scope from their challenges.
+	scopes := hintScopes(hint)
+	if len(scopes) == 0 && challenge.Scope() == nil {
+		if scope := requestScope(request); scope != "" {
+			scopes = []string{scope}
+		}
+	}
+	challenge, _ = challenge.Widen(scopes...)
+
+	token, err = r.authenticator.Authenticate(credentialContext(request), challenge, false)
 
 	if err != nil {
 		return
@@ -105,6 +131,7 @@ func (r *tokenAuthConnector) Request(
 		}
 	}
 
+	r.stat.Retry()
 	response, err = r.attemptRequestWithToken(request, token)
 
 	if err == nil &&
@@ -113,43 +140,102 @@ func (r *tokenAuthConnector) Request(
 
 		r.stat.CacheFailAtAuthLevel()
 
-		token, err = r.authenticator.Authenticate(challenge, true)
+		token, err = r.authenticator.Authenticate(credentialContext(request), challenge, true)
 
 		if err != nil {
 			return
 		}
 
+		r.stat.Retry()
 		response, err = r.attemptRequestWithToken(request, token)
 	}
 
+	if err == nil {
+		if widened, ok := widenedChallenge(challenge, response); ok {
+			response.Body.Close()
+
+			challenge = widened
+			token, err = r.authenticator.Authenticate(credentialContext(request), challenge, true)
+
+			if err != nil {
+				return
+			}
+
+			r.stat.Retry()
+			response, err = r.attemptRequestWithToken(request, token)
+		}
+	}
+
 	if hint != "" && err == nil && response.StatusCode != http.StatusUnauthorized {
-		r.tokenCache.Set(hint, token)
+		r.tokenCache.Set(generation, hint, token, challenge)
 	}
 
 	return
 }
 
-var challengeRegex2 *regexp.Regexp = regexp.MustCompile(
-	`^\s*Bearer\s+realm="([^"]+)",service="([^"]+)"$`)
+// refreshToken renews a cached token ahead of its expiry, so requests do not
+// have to wait for a challenge round trip.
+func (r *tokenAuthConnector) refreshToken(ctx context.Context, generation uint64, hint string, challenge *auth.Challenge) {
+	info, _ := RequestInfoFromContext(ctx)
+	info.Operation = OperationTokenFetch
+	ctx = WithRequestInfo(ctx, info)
 
-func getAuthenticate(reqUrl, auth string) string {
-	// Www-Authenticate: Bearer realm="https://auth.m.daocloud.io/auth/token",service="docker.m.daocloud.io"
-	// ,scope="repository:linkease/linkease:pull"
-	// GET /v2/linkease/linkease/manifests/1.6.7 HTTP/1.1
-	if strings.Contains(auth, "scope") {
-		return auth
+	token, err := r.authenticator.Authenticate(ctx, challenge, true)
+	if err != nil {
+		r.tokenCache.RefreshFailed(hint)
+		return
 	}
-	reqStrs := strings.SplitN(reqUrl, "/", 5)
-	if len(reqStrs) < 5 {
-		return auth
+
+	r.tokenCache.Set(generation, hint, token, challenge)
+}
+
+// AuthorizeScopes reuses the challenge of the /v2/ ping, which is sent once
+// per host.
+func (r *tokenAuthConnector) AuthorizeScopes(ctx context.Context, registryUrl *url.URL, scopes ...string) (token auth.Token, err error) {
+	ping, err := r.ping(ctx, registryUrl)
+	if err != nil || !ping.unauthorized {
+		return
 	}
-	match := challengeRegex2.FindAllStringSubmatch(auth, -1)
-	if len(match) == 1 {
-		newAuth := fmt.Sprintf("%s,scope=\"repository:%s/%s:pull\"", auth, reqStrs[2], reqStrs[3])
-		//fmt.Println("Change to", newAuth)
-		return newAuth
+
+	challenge, err := auth.ParseChallengeHeaders(ping.challenges)
+	if err != nil {
+		err = newChallengeError(ping.challenges, err)
+		return
+	}
+	challenge, _ = challenge.Widen(scopes...)
+
+	// The repository credentials are resolved for is taken from the scopes.
+	return r.authenticator.Authenticate(auth.WithCredentialTarget(requestContext(ctx, ""), registryUrl.Host, ""), challenge, false)
+}
+
+// InvalidateTokens drops all cached tokens, e.g. after a credential change.
+// Requests in flight finish with the tokens they hold.
+func (r *tokenAuthConnector) InvalidateTokens() {
+	r.tokenCache.Invalidate()
+	r.pings.Invalidate()
+
+	if invalidator, ok := r.authenticator.(auth.InvalidatingAuthenticator); ok {
+		invalidator.Invalidate()
 	}
-	return auth
+}
+
+// widenedChallenge returns the challenge to retry with if the registry
+// rejected the token for lacking scopes.
+func widenedChallenge(challenge *auth.Challenge, response *http.Response) (*auth.Challenge, bool) {
+	if response.StatusCode != http.StatusUnauthorized && response.StatusCode != http.StatusForbidden {
+		return nil, false
+	}
+
+	required, err := auth.ParseChallengeHeaders(response.Header.Values("www-authenticate"))
+	if err != nil || !required.InsufficientScope() || !sameAuthority(challenge, required) {
+		return nil, false
+	}
+
+	return challenge.Widen(required.Scope()...)
+}
+
+func sameAuthority(a, b *auth.Challenge) bool {
+	return a.Realm().String() == b.Realm().String() && a.Service() == b.Service()
 }
 
 func (r *tokenAuthConnector) attemptRequestWithToken(request *http.Request, token auth.Token) (*http.Response, error) {
@@ -157,20 +243,8 @@ func (r *tokenAuthConnector) attemptRequestWithToken(request *http.Request, toke
 		request.Header.Set("Authorization", "Bearer "+token.Value())
 	}
 
-	/* b, err2 := httputil.DumpRequest(request, true)
-	if err2 == nil {
-		fmt.Println(string(b)) comment
-	} */
-
 	resp, err := r.httpClient.Do(request)
 
-	/* if err == nil {
-		b, err2 := httputil.DumpResponse(resp, false)
-		if err2 == nil {
-			fmt.Println(string(b)) comment
-		}
-	} */
-
 	return resp, err
 }
 
@@ -179,22 +253,62 @@ func (r *tokenAuthConnector) GetStatistics() Statistics {
 }
 
 func NewTokenAuthConnector(cfg Config) Connector {
+	limiter := newLimiter(cfg)
+	return newTokenAuthConnector(cfg, limiter, newStatistics(limiter))
+}
+
+// anonymousFallbackHandler counts anonymous fallbacks before passing them on
+// to the handler of the config; nil if the fallback is disabled.
+func (r *tokenAuthConnector) anonymousFallbackHandler() auth.AnonymousFallbackHandler {
+	if !r.cfg.AnonymousFallback() {
+		return nil
+	}
+
+	handler := r.cfg.AnonymousFallbackHandler()
+
+	return func(account string, scopes []string, rejection error) {
+		r.stat.AnonymousFallback()
+
+		if handler != nil {
+			handler(account, scopes, rejection)
+		}
+	}
+}
+
+func newTokenAuthConnector(cfg Config, limiter limiter, stat *statistics) *tokenAuthConnector {
 	connector := tokenAuthConnector{
 		cfg:        cfg,
 		httpClient: cfg.HttpClient(),
-		semaphore:  newSemaphore(cfg.MaxConcurrentRequests()),
+		limiter:    limiter,
 		tokenCache: newTokenCache(),
-		stat:       new(statistics),
+		pings:      newPingCache(),
+		stat:       stat,
 	}
 	if connector.httpClient == nil {
 		connector.httpClient = createHttpClient(cfg)
 	}
 
+	authHttpClient := wrapHttpClient(
+		instrumentHttpClient(connector.httpClient, connector.stat, classifyAuthEndpoint),
+		cfg,
+		OperationTokenFetch,
+	)
+	connector.httpClient = wrapHttpClient(
+		withRedirectPolicy(instrumentHttpClient(connector.httpClient, connector.stat, classifyRegistryEndpoint), connector.stat),
+		cfg,
+		OperationUnknown,
+	)
+
 	connector.authenticator = auth.NewAuthenticator(
-		connector.httpClient,
-		cfg.Credentials(),
+		authHttpClient,
+		cfg.CredentialProvider(),
 		cfg.FastChannel(),
 		cfg.FastChannelTokenProvider(),
+		auth.AuthenticatorOptions{
+			OAuth2:            cfg.OAuth2Options(),
+			TokenCacheFile:    cfg.TokenCacheFile(),
+			AnonymousFallback: connector.anonymousFallbackHandler(),
+		},
 	)
 
 	//setInvalidTokenForTest(&connector)
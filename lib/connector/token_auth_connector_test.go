@@ -0,0 +1,150 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kspeeder/docker-registry/lib/auth"
+)
+
+type expiringToken struct {
+	value     string
+	expiresAt time.Time
+}
+
+func (t *expiringToken) Value() string        { return t.value }
+func (t *expiringToken) Fresh() bool          { return true }
+func (t *expiringToken) ExpiresAt() time.Time { return t.expiresAt }
+
+type refreshResult struct {
+	token auth.Token
+	err   error
+}
+
+// refreshingAuthenticator issues tokens right away, but hands refreshes to
+// the test.
+type refreshingAuthenticator struct {
+	refreshes chan struct{}
+	results   chan refreshResult
+}
+
+func (a *refreshingAuthenticator) Authenticate(ctx context.Context, challenge *auth.Challenge, ignoreCached bool) (auth.Token, error) {
+	if !ignoreCached {
+		return &expiringToken{value: "initial", expiresAt: time.Now().Add(time.Hour)}, nil
+	}
+
+	a.refreshes <- struct{}{}
+	result := <-a.results
+	return result.token, result.err
+}
+
+func (a *refreshingAuthenticator) Invalidate() {}
+
+func TestTokenRefreshAhead(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			return
+		}
+
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="test",scope="repository:foo:pull"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	authenticator := &refreshingAuthenticator{
+		refreshes: make(chan struct{}),
+		results:   make(chan refreshResult),
+	}
+	limiter := newSemaphore(4)
+	connector := &tokenAuthConnector{
+		httpClient:    server.Client(),
+		authenticator: authenticator,
+		limiter:       limiter,
+		tokenCache:    newTokenCache(),
+		stat:          newStatistics(limiter),
+	}
+
+	tagsUrl, _ := url.Parse(server.URL + "/v2/foo/tags/list")
+	get := func() {
+		response, err := connector.Get(context.Background(), tagsUrl, nil, "foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status %d", response.StatusCode)
+		}
+	}
+
+	// entry inspects the cached token under the cache lock.
+	entry := func(inspect func(entry *tokenCacheEntry)) {
+		connector.tokenCache.mutex.Lock()
+		defer connector.tokenCache.mutex.Unlock()
+
+		for _, entry := range connector.tokenCache.entries {
+			inspect(entry)
+		}
+	}
+
+	expectRefresh := func(expected bool) {
+		timeout := 50 * time.Millisecond
+		if expected {
+			timeout = time.Second
+		}
+
+		select {
+		case <-authenticator.refreshes:
+			if !expected {
+				t.Fatal("unexpected token refresh")
+			}
+		case <-time.After(timeout):
+			if expected {
+				t.Fatal("expected a token refresh")
+			}
+		}
+	}
+
+	waitFor := func(condition func(entry *tokenCacheEntry) bool) {
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			done := false
+			entry(func(entry *tokenCacheEntry) { done = condition(entry) })
+			if done {
+				return
+			}
+		}
+		t.Fatal("condition not reached")
+	}
+
+	get()
+	expectRefresh(false)
+
+	// Less than a fifth of the lifetime left.
+	entry(func(entry *tokenCacheEntry) { entry.storedAt = time.Now().Add(-10 * time.Hour) })
+
+	get()
+	expectRefresh(true)
+
+	// Requests keep using the cached token while a refresh is running.
+	get()
+	expectRefresh(false)
+
+	authenticator.results <- refreshResult{err: errors.New("auth server unavailable")}
+	waitFor(func(entry *tokenCacheEntry) bool { return !entry.refreshing })
+
+	get()
+	expectRefresh(true)
+
+	authenticator.results <- refreshResult{token: &expiringToken{value: "refreshed", expiresAt: time.Now().Add(time.Hour)}}
+	waitFor(func(entry *tokenCacheEntry) bool { return entry.token.Value() == "refreshed" })
+
+	get()
+	expectRefresh(false)
+}
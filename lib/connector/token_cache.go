@@ -2,33 +2,119 @@ package connector
 
 import (
 	"sync"
+	"time"
 
 	"github.com/kspeeder/docker-registry/lib/auth"
 )
 
+// Tokens are refreshed in the background once less than this share of their
+// lifetime is left.
+const refreshShare = 5
+
+type tokenCacheEntry struct {
+	token      auth.Token
+	challenge  *auth.Challenge
+	storedAt   time.Time
+	refreshing bool
+}
+
+func (e *tokenCacheEntry) expired(now time.Time) bool {
+	return !now.Before(e.token.ExpiresAt())
+}
+
+func (e *tokenCacheEntry) refreshDue(now time.Time) bool {
+	lifetime := e.token.ExpiresAt().Sub(e.storedAt)
+	return e.token.ExpiresAt().Sub(now) < lifetime/refreshShare
+}
+
 type tokenCache struct {
-	entries map[string]auth.Token
-	mutex   sync.RWMutex
+	entries map[string]*tokenCacheEntry
+	// generation is bumped on invalidation, so tokens obtained before are
+	// not cached again by requests that were in flight.
+	generation uint64
+	mutex      sync.Mutex
+}
+
+func (t *tokenCache) Generation() uint64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.generation
+}
+
+func (t *tokenCache) Invalidate() {
+	t.mutex.Lock()
+	t.entries = make(map[string]*tokenCacheEntry)
+	t.generation++
+	t.mutex.Unlock()
+}
+
+// Get returns the cached token for hint. If the token is about to expire,
+// refresh is the challenge to renew it with; only one caller is handed the
+// challenge until the token is replaced or the refresh fails.
+func (t *tokenCache) Get(hint string) (token auth.Token, refresh *auth.Challenge) {
+	now := time.Now()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	entry, cached := t.entries[hint]
+	if !cached {
+		return
+	}
+
+	if entry.expired(now) {
+		delete(t.entries, hint)
+		return
+	}
+
+	if !entry.refreshing && entry.challenge != nil && entry.refreshDue(now) {
+		entry.refreshing = true
+		refresh = entry.challenge
+	}
+
+	return entry.token, refresh
+}
+
+// Challenge returns the challenge the cached token for hint was issued for.
+func (t *tokenCache) Challenge(hint string) *auth.Challenge {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if entry, cached := t.entries[hint]; cached {
+		return entry.challenge
+	}
+
+	return nil
 }
 
-func (t *tokenCache) Get(hint string) (token auth.Token) {
-	t.mutex.RLock()
-	if value, cached := t.entries[hint]; cached {
-		token = value
+// Set caches a token obtained in the given generation.
+func (t *tokenCache) Set(generation uint64, hint string, token auth.Token, challenge *auth.Challenge) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if generation != t.generation {
+		return
 	}
-	t.mutex.RUnlock()
 
-	return
+	t.entries[hint] = &tokenCacheEntry{
+		token:     token,
+		challenge: challenge,
+		storedAt:  time.Now(),
+	}
 }
 
-func (t *tokenCache) Set(hint string, token auth.Token) {
+// RefreshFailed allows another refresh attempt for hint.
+func (t *tokenCache) RefreshFailed(hint string) {
 	t.mutex.Lock()
-	t.entries[hint] = token
+	if entry, cached := t.entries[hint]; cached {
+		entry.refreshing = false
+	}
 	t.mutex.Unlock()
 }
 
 func newTokenCache() *tokenCache {
 	return &tokenCache{
-		entries: make(map[string]auth.Token),
+		entries: make(map[string]*tokenCacheEntry),
 	}
 }
@@ -1,26 +0,0 @@
-package connector
-
-import "net/http"
-
-type userAgentTransport struct {
-	userAgent string
-	transport http.RoundTripper
-}
-
-func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	req = cloneRequest(req) // per RoundTrip contract
-	req.Header.Set("User-Agent", t.userAgent)
-	return t.transport.RoundTrip(req)
-}
-
-func cloneRequest(r *http.Request) *http.Request {
-	// shallow copy of the struct
-	r2 := new(http.Request)
-	*r2 = *r
-	// deep copy of the Header
-	r2.Header = make(http.Header, len(r.Header))
-	for k, s := range r.Header {
-		r2.Header[k] = append([]string(nil), s...)
-	}
-	return r2
-}
@@ -3,6 +3,9 @@ package lib
 import "github.com/kspeeder/docker-registry/lib/connector"
 
 func createConnector(cfg *Config) connector.Connector {
+	if cfg.autoAuth {
+		return connector.NewAutoAuthConnector(cfg)
+	}
 	if cfg.basicAuth {
 		return connector.NewBasicAuthConnector(cfg)
 	}
@@ -0,0 +1,130 @@
+package lib
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kspeeder/docker-registry/lib/auth"
+)
+
+type credentialProviderChain []auth.CredentialProvider
+
+// NewCredentialProviderChain returns a provider asking the given providers in
+// order; the first one having credentials wins.
+func NewCredentialProviderChain(providers ...auth.CredentialProvider) auth.CredentialProvider {
+	return credentialProviderChain(providers)
+}
+
+func (c credentialProviderChain) Credentials(ctx context.Context, registry, repository string) (credentials auth.Credentials, ok bool, err error) {
+	for _, provider := range c {
+		credentials, ok, err = provider.Credentials(ctx, registry, repository)
+		if err != nil || ok {
+			return
+		}
+	}
+
+	return
+}
+
+type scopedCredentialProvider struct {
+	registry string
+	prefix   string
+	provider auth.CredentialProvider
+}
+
+// NewScopedCredentialProvider restricts a provider to a registry host and,
+// optionally, a repository path prefix, e.g. "registry.example.com/team-a".
+// Chain scoped providers from the most to the least specific scope.
+func NewScopedCredentialProvider(scope string, provider auth.CredentialProvider) auth.CredentialProvider {
+	registry, prefix, _ := strings.Cut(strings.Trim(scope, "/"), "/")
+
+	return &scopedCredentialProvider{
+		registry: normalizeRegistryHost(registry),
+		prefix:   prefix,
+		provider: provider,
+	}
+}
+
+func (s *scopedCredentialProvider) Credentials(ctx context.Context, registry, repository string) (credentials auth.Credentials, ok bool, err error) {
+	if normalizeRegistryHost(registry) != s.registry || !hasRepositoryPrefix(repository, s.prefix) {
+		return
+	}
+
+	return s.provider.Credentials(ctx, registry, repository)
+}
+
+func hasRepositoryPrefix(repository, prefix string) bool {
+	return prefix == "" || repository == prefix || strings.HasPrefix(repository, prefix+"/")
+}
+
+// normalizeRegistryHost maps the aliases of Docker Hub to a single name.
+func normalizeRegistryHost(host string) string {
+	host = strings.ToLower(host)
+
+	switch host {
+	case "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return "docker.io"
+	}
+
+	return host
+}
+
+// dockerConfigServerAddress returns the key docker stores the credentials of
+// a registry host under; Docker Hub logins use the legacy index URL.
+func dockerConfigServerAddress(host string) string {
+	if normalizeRegistryHost(host) == "docker.io" {
+		return "https://index.docker.io/v1/"
+	}
+
+	return host
+}
+
+// Results of credential stores and helpers are reused for this long to avoid
+// spawning a process per request.
+const credentialCacheTTL = 5 * time.Minute
+
+type cachedCredentials struct {
+	credentials auth.Credentials
+	ok          bool
+	fetched     time.Time
+}
+
+type credentialResultCache struct {
+	results map[string]cachedCredentials
+	mutex   sync.Mutex
+}
+
+func newCredentialResultCache() *credentialResultCache {
+	return &credentialResultCache{
+		results: make(map[string]cachedCredentials),
+	}
+}
+
+// Get returns the credentials cached for key, or looks them up with resolve.
+// Errors are not cached.
+func (c *credentialResultCache) Get(key string, resolve func() (auth.Credentials, bool, error)) (credentials auth.Credentials, ok bool, err error) {
+	c.mutex.Lock()
+	result, cached := c.results[key]
+	c.mutex.Unlock()
+
+	if cached && time.Since(result.fetched) < credentialCacheTTL {
+		return result.credentials, result.ok, nil
+	}
+
+	credentials, ok, err = resolve()
+	if err != nil {
+		return
+	}
+
+	c.mutex.Lock()
+	c.results[key] = cachedCredentials{
+		credentials: credentials,
+		ok:          ok,
+		fetched:     time.Now(),
+	}
+	c.mutex.Unlock()
+
+	return
+}
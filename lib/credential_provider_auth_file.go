@@ -0,0 +1,205 @@
+package lib
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kspeeder/docker-registry/lib/auth"
+)
+
+type authFileEntry struct {
+	Auth          string `json:"auth"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identitytoken"`
+}
+
+// authFile is the auth.json format shared by podman, skopeo and buildah.
+type authFile struct {
+	Auths       map[string]authFileEntry `json:"auths"`
+	CredHelpers map[string]string        `json:"credHelpers"`
+}
+
+type authFileCredentialProvider struct {
+	path string
+	// The parsed file is reused until the file changes.
+	entries     map[string]authFileEntry
+	credHelpers map[string]string
+	modTime     time.Time
+	loadPath    string
+	// helpers keeps one provider, and thereby one result cache, per helper.
+	helpers map[string]auth.CredentialProvider
+	mutex   sync.Mutex
+}
+
+// DefaultAuthFilePath returns $REGISTRY_AUTH_FILE or, failing that,
+// $XDG_RUNTIME_DIR/containers/auth.json.
+func DefaultAuthFilePath() string {
+	if path := os.Getenv("REGISTRY_AUTH_FILE"); path != "" {
+		return path
+	}
+
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return filepath.Join(runtimeDir, "containers", "auth.json")
+	}
+
+	return ""
+}
+
+// NewAuthFileCredentialProvider reads credentials from a containers auth.json
+// file, DefaultAuthFilePath() if path is empty. Entries may be keyed by
+// registry or by registry and repository prefix; the most specific entry
+// wins. A missing file provides no credentials.
+func NewAuthFileCredentialProvider(path string) auth.CredentialProvider {
+	return &authFileCredentialProvider{
+		path:    path,
+		helpers: make(map[string]auth.CredentialProvider),
+	}
+}
+
+func (a *authFileCredentialProvider) Credentials(ctx context.Context, registry, repository string) (credentials auth.Credentials, ok bool, err error) {
+	entries, helpers, err := a.load()
+	if err != nil {
+		return
+	}
+
+	for _, key := range authFileLookupKeys(normalizeRegistryHost(registry), repository) {
+		if entry, found := entries[key]; found {
+			return entry.credentials()
+		}
+	}
+
+	for key, helper := range helpers {
+		if normalizeAuthFileKey(key) == normalizeRegistryHost(registry) {
+			return a.helper(helper).Credentials(ctx, registry, repository)
+		}
+	}
+
+	return
+}
+
+// load returns the normalized entries and the credential helpers of the auth
+// file, parsing it again only if it changed.
+func (a *authFileCredentialProvider) load() (entries map[string]authFileEntry, helpers map[string]string, err error) {
+	path := a.path
+	if path == "" {
+		path = DefaultAuthFilePath()
+	}
+	if path == "" {
+		return
+	}
+
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if path == a.loadPath && info.ModTime().Equal(a.modTime) {
+		return a.entries, a.credHelpers, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return
+	}
+
+	var file authFile
+	if err = json.Unmarshal(data, &file); err != nil {
+		err = fmt.Errorf("malformed auth file %s: %v", path, err)
+		return
+	}
+
+	entries = make(map[string]authFileEntry, len(file.Auths))
+	for key, entry := range file.Auths {
+		entries[normalizeAuthFileKey(key)] = entry
+	}
+
+	a.entries, a.credHelpers, a.modTime, a.loadPath = entries, file.CredHelpers, info.ModTime(), path
+
+	return entries, file.CredHelpers, nil
+}
+
+func (a *authFileCredentialProvider) helper(name string) auth.CredentialProvider {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	provider, found := a.helpers[name]
+	if !found {
+		provider = NewCredentialHelperProvider(name)
+		a.helpers[name] = provider
+	}
+
+	return provider
+}
+
+func (e authFileEntry) credentials() (credentials auth.Credentials, ok bool, err error) {
+	credentials = auth.Credentials{
+		Username:      e.Username,
+		Password:      e.Password,
+		IdentityToken: e.IdentityToken,
+	}
+
+	if e.Auth != "" {
+		decoded, decodeErr := base64.StdEncoding.DecodeString(e.Auth)
+		user, password, found := strings.Cut(string(decoded), ":")
+		if decodeErr != nil || !found {
+			err = errors.New("malformed auth entry in auth file")
+			return
+		}
+
+		credentials.Username, credentials.Password = user, password
+	}
+
+	return credentials, !credentials.IsBlank(), nil
+}
+
+// authFileLookupKeys lists host/a/b/c, host/a/b, host/a and host for the
+// repository a/b/c.
+func authFileLookupKeys(registry, repository string) (keys []string) {
+	path := registry
+	if repository != "" {
+		path += "/" + repository
+	}
+
+	for {
+		keys = append(keys, path)
+
+		separator := strings.LastIndex(path, "/")
+		if separator < 0 {
+			return
+		}
+		path = path[:separator]
+	}
+}
+
+// normalizeAuthFileKey strips schemes and API paths from legacy docker style
+// keys like https://index.docker.io/v1/.
+func normalizeAuthFileKey(key string) string {
+	key = strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	key = strings.TrimSuffix(key, "/")
+	key = strings.TrimSuffix(strings.TrimSuffix(key, "/v1"), "/v2")
+
+	host, path, hasPath := strings.Cut(key, "/")
+	if hasPath {
+		return normalizeRegistryHost(host) + "/" + path
+	}
+
+	return normalizeRegistryHost(host)
+}
@@ -0,0 +1,49 @@
+package lib
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/kspeeder/docker-registry/lib/auth"
+)
+
+type dockerConfigCredentialProvider struct {
+	dockerConfig *configfile.ConfigFile
+	once         sync.Once
+	results      *credentialResultCache
+}
+
+// NewDockerConfigCredentialProvider reads credentials from the default docker
+// config file, including its credential stores and helpers. Lookups are
+// cached for a few minutes.
+func NewDockerConfigCredentialProvider() auth.CredentialProvider {
+	return &dockerConfigCredentialProvider{
+		results: newCredentialResultCache(),
+	}
+}
+
+func (d *dockerConfigCredentialProvider) Credentials(ctx context.Context, registry, repository string) (credentials auth.Credentials, ok bool, err error) {
+	d.once.Do(func() {
+		d.dockerConfig = config.LoadDefaultConfigFile(io.Discard)
+	})
+
+	serverAddress := dockerConfigServerAddress(registry)
+
+	return d.results.Get(serverAddress, func() (credentials auth.Credentials, ok bool, err error) {
+		authConfig, err := d.dockerConfig.GetCredentialsStore(serverAddress).Get(serverAddress)
+		if err != nil {
+			return
+		}
+
+		credentials = auth.Credentials{
+			Username:      authConfig.Username,
+			Password:      authConfig.Password,
+			IdentityToken: authConfig.IdentityToken,
+		}
+
+		return credentials, !credentials.IsBlank(), nil
+	})
+}
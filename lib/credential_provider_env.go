@@ -0,0 +1,31 @@
+package lib
+
+import (
+	"context"
+	"os"
+
+	"github.com/kspeeder/docker-registry/lib/auth"
+)
+
+type envCredentialProvider struct {
+	prefix string
+}
+
+// NewEnvCredentialProvider reads credentials from the environment variables
+// <prefix>USERNAME, <prefix>PASSWORD and <prefix>IDENTITY_TOKEN, e.g.
+// REGISTRY_USERNAME for the prefix "REGISTRY_".
+func NewEnvCredentialProvider(prefix string) auth.CredentialProvider {
+	return &envCredentialProvider{
+		prefix: prefix,
+	}
+}
+
+func (e *envCredentialProvider) Credentials(ctx context.Context, registry, repository string) (credentials auth.Credentials, ok bool, err error) {
+	credentials = auth.Credentials{
+		Username:      os.Getenv(e.prefix + "USERNAME"),
+		Password:      os.Getenv(e.prefix + "PASSWORD"),
+		IdentityToken: os.Getenv(e.prefix + "IDENTITY_TOKEN"),
+	}
+
+	return credentials, !credentials.IsBlank(), nil
+}
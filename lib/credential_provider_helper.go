@@ -0,0 +1,71 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/kspeeder/docker-registry/lib/auth"
+)
+
+type credentialHelperProvider struct {
+	helper  string
+	results *credentialResultCache
+}
+
+// NewCredentialHelperProvider runs the docker credential helper
+// docker-credential-<helper>, e.g. "pass" or "ecr-login".
+func NewCredentialHelperProvider(helper string) auth.CredentialProvider {
+	return &credentialHelperProvider{
+		helper:  helper,
+		results: newCredentialResultCache(),
+	}
+}
+
+func (h *credentialHelperProvider) Credentials(ctx context.Context, registry, repository string) (credentials auth.Credentials, ok bool, err error) {
+	serverUrl := dockerConfigServerAddress(registry)
+
+	return h.results.Get(serverUrl, func() (auth.Credentials, bool, error) {
+		return h.get(ctx, serverUrl)
+	})
+}
+
+// get implements the "get" command of the credential helper protocol.
+func (h *credentialHelperProvider) get(ctx context.Context, serverUrl string) (credentials auth.Credentials, ok bool, err error) {
+	var stdout, stderr bytes.Buffer
+
+	command := exec.CommandContext(ctx, "docker-credential-"+h.helper, "get")
+	command.Stdin = strings.NewReader(serverUrl)
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+
+	if err = command.Run(); err != nil {
+		message := strings.TrimSpace(stdout.String() + stderr.String())
+		if strings.Contains(message, "credentials not found") {
+			return credentials, false, nil
+		}
+
+		err = fmt.Errorf("credential helper %s failed: %v: %s", h.helper, err, message)
+		return
+	}
+
+	var response struct {
+		Username string
+		Secret   string
+	}
+	if err = json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		err = fmt.Errorf("credential helper %s returned malformed output: %v", h.helper, err)
+		return
+	}
+
+	if response.Username == "<token>" {
+		credentials.IdentityToken = response.Secret
+	} else {
+		credentials.Username, credentials.Password = response.Username, response.Secret
+	}
+
+	return credentials, !credentials.IsBlank(), nil
+}
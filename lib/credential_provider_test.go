@@ -0,0 +1,113 @@
+package lib
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/kspeeder/docker-registry/lib/auth"
+)
+
+func resolve(t *testing.T, provider auth.CredentialProvider, registry, repository string) (auth.Credentials, bool) {
+	credentials, ok, err := provider.Credentials(context.Background(), registry, repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return credentials, ok
+}
+
+func TestAuthFileCredentialProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.json")
+	content := `{"auths": {
+		"registry.example.com": {"auth": "aG9zdDpob3N0LXNlY3JldA=="},
+		"registry.example.com/team-a": {"username": "robot-a", "password": "a-secret"},
+		"https://index.docker.io/v1/": {"identitytoken": "hub-token"}
+	}}`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	provider := NewAuthFileCredentialProvider(path)
+
+	if credentials, _ := resolve(t, provider, "registry.example.com", "team-a/app"); credentials.Username != "robot-a" {
+		t.Fatalf("expected the namespace robot account, got %+v", credentials)
+	}
+
+	if credentials, _ := resolve(t, provider, "registry.example.com", "team-ab/app"); credentials.Username != "host" || credentials.Password != "host-secret" {
+		t.Fatalf("expected the registry wide account, got %+v", credentials)
+	}
+
+	if credentials, _ := resolve(t, provider, "registry-1.docker.io", "library/alpine"); credentials.IdentityToken != "hub-token" {
+		t.Fatalf("expected the Docker Hub identity token, got %+v", credentials)
+	}
+
+	if _, ok := resolve(t, provider, "other.example.com", "app"); ok {
+		t.Fatal("unknown registries should have no credentials")
+	}
+
+	if _, ok := resolve(t, NewAuthFileCredentialProvider(filepath.Join(t.TempDir(), "missing.json")), "registry.example.com", ""); ok {
+		t.Fatal("a missing auth file should have no credentials")
+	}
+}
+
+func TestScopedCredentialProviderChain(t *testing.T) {
+	t.Setenv("TEST_REGISTRY_USERNAME", "env-user")
+	t.Setenv("TEST_REGISTRY_PASSWORD", "env-password")
+
+	teamA := NewRegistryCredentials("robot-a", "a-secret")
+	chain := NewCredentialProviderChain(
+		NewScopedCredentialProvider("registry.example.com/team-a", &teamA),
+		NewScopedCredentialProvider("registry.example.com", NewEnvCredentialProvider("TEST_REGISTRY_")),
+	)
+
+	if credentials, _ := resolve(t, chain, "registry.example.com", "team-a/sub/app"); credentials.Username != "robot-a" {
+		t.Fatalf("expected the team-a account, got %+v", credentials)
+	}
+
+	if credentials, _ := resolve(t, chain, "registry.example.com", "team-b/app"); credentials.Username != "env-user" {
+		t.Fatalf("expected the environment account, got %+v", credentials)
+	}
+
+	if _, ok := resolve(t, chain, "other.example.com", "team-a/app"); ok {
+		t.Fatal("scoped providers must not leak to other registries")
+	}
+}
+
+func TestCredentialHelperProvider(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell script helper")
+	}
+
+	dir := t.TempDir()
+	script := "#!/bin/sh\nread server\nif [ \"$server\" = registry.example.com ]; then\n  echo '{\"ServerURL\":\"registry.example.com\",\"Username\":\"<token>\",\"Secret\":\"refresh\"}'\nelse\n  echo 'credentials not found in native keychain'\n  exit 1\nfi\n"
+	if err := os.WriteFile(filepath.Join(dir, "docker-credential-test"), []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	provider := NewCredentialHelperProvider("test")
+
+	if credentials, ok := resolve(t, provider, "registry.example.com", "app"); !ok || credentials.IdentityToken != "refresh" {
+		t.Fatalf("unexpected helper credentials %+v", credentials)
+	}
+
+	if _, ok := resolve(t, provider, "other.example.com", "app"); ok {
+		t.Fatal("helpers without credentials should not provide any")
+	}
+}
+
+func TestStoredIdentityTokenIsFound(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	if err := storeIdentityToken(DEFAULT_REGISTRY_URL, "user", "refresh"); err != nil {
+		t.Fatal(err)
+	}
+
+	credentials, ok := resolve(t, NewDockerConfigCredentialProvider(), "registry-1.docker.io", "library/alpine")
+	if !ok || credentials.IdentityToken != "refresh" {
+		t.Fatalf("expected the stored Docker Hub token, got %+v", credentials)
+	}
+}
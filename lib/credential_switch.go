@@ -0,0 +1,33 @@
+package lib
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/kspeeder/docker-registry/lib/auth"
+)
+
+type credentialProviderHolder struct {
+	provider auth.CredentialProvider
+}
+
+// credentialSwitch is the credential provider of a live API; it can be
+// replaced while requests are running.
+type credentialSwitch struct {
+	current atomic.Pointer[credentialProviderHolder]
+}
+
+func (s *credentialSwitch) Set(provider auth.CredentialProvider) {
+	s.current.Store(&credentialProviderHolder{provider: provider})
+}
+
+func (s *credentialSwitch) Credentials(ctx context.Context, registry, repository string) (auth.Credentials, bool, error) {
+	return s.current.Load().provider.Credentials(ctx, registry, repository)
+}
+
+func newCredentialSwitch(provider auth.CredentialProvider) *credentialSwitch {
+	s := &credentialSwitch{}
+	s.Set(provider)
+
+	return s
+}
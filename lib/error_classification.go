@@ -0,0 +1,113 @@
+package lib
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+
+	"github.com/kspeeder/docker-registry/lib/auth"
+)
+
+// The predicates below classify errors returned by the API, including wrapped
+// ones.
+
+// IsNotFound tells if the registry does not know a repository, manifest or
+// blob.
+func IsNotFound(err error) bool {
+	var notFound NotFoundError
+
+	return errors.As(err, &notFound) ||
+		errors.Is(err, ErrorCodeNameUnknown) ||
+		errors.Is(err, ErrorCodeManifestUnknown) ||
+		errors.Is(err, ErrorCodeBlobUnknown)
+}
+
+// IsUnauthorized tells if the registry or its auth server rejected the
+// credentials or denied access.
+func IsUnauthorized(err error) bool {
+	var authorizationErr AutorizationError
+	if errors.As(err, &authorizationErr) || errors.Is(err, ErrorCodeUnauthorized) || errors.Is(err, ErrorCodeDenied) {
+		return true
+	}
+
+	statusCode, ok := auth.AuthServerStatusCode(err)
+	return ok && (statusCode == http.StatusBadRequest || statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden)
+}
+
+func IsRateLimited(err error) bool {
+	statusCode, _ := errorStatusCode(err)
+	return statusCode == http.StatusTooManyRequests || errors.Is(err, ErrorCodeTooManyRequests)
+}
+
+// IsTransient tells if err is a server or network failure that may go away by
+// itself. Certificate errors and cancellations are not transient.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || isTLSError(err) {
+		return false
+	}
+
+	if statusCode, ok := errorStatusCode(err); ok {
+		switch statusCode {
+		case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		default:
+			return false
+		}
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
+
+// IsRetryable tells if repeating the request, possibly after a delay, may
+// succeed.
+func IsRetryable(err error) bool {
+	return IsTransient(err) || IsRateLimited(err)
+}
+
+func errorStatusCode(err error) (statusCode int, ok bool) {
+	var registryErr *RegistryError
+	if errors.As(err, &registryErr) {
+		return registryErr.StatusCode, true
+	}
+
+	return auth.AuthServerStatusCode(err)
+}
+
+func isTLSError(err error) bool {
+	var verificationErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+
+	return errors.As(err, &verificationErr) ||
+		errors.As(err, &recordErr) ||
+		errors.As(err, &alertErr) ||
+		errors.As(err, &authorityErr) ||
+		errors.As(err, &hostnameErr) ||
+		errors.As(err, &invalidErr)
+}
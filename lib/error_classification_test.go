@@ -0,0 +1,55 @@
+package lib
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+)
+
+func TestErrorClassification(t *testing.T) {
+	dialErr := &url.Error{Op: "Get", URL: "https://registry.example/v2/", Err: &net.OpError{
+		Op:  "dial",
+		Net: "tcp",
+		Err: syscall.ECONNREFUSED,
+	}}
+	certErr := &url.Error{Op: "Get", URL: "https://registry.example/v2/", Err: x509.UnknownAuthorityError{}}
+	notFound := &RegistryError{
+		StatusCode: 404,
+		Errors:     []ErrorDetail{{Code: ErrorCodeManifestUnknown}},
+		legacy:     newNotFoundError("manifest not found"),
+	}
+	rateLimited := &RegistryError{
+		StatusCode: 429,
+		Errors:     []ErrorDetail{{Code: ErrorCodeTooManyRequests}},
+		legacy:     newInvalidStatusCodeError(429),
+	}
+	unavailable := &RegistryError{StatusCode: 503, legacy: newInvalidStatusCodeError(503)}
+	denied := &RegistryError{StatusCode: 403, legacy: genericAuthorizationError}
+
+	cases := []struct {
+		err                                            error
+		notFound, unauthorized, rateLimited, transient bool
+	}{
+		{err: fmt.Errorf("listing tags: %w", notFound), notFound: true},
+		{err: newNotFoundError("foo: no such repository"), notFound: true},
+		{err: denied, unauthorized: true},
+		{err: rateLimited, rateLimited: true},
+		{err: unavailable, transient: true},
+		{err: dialErr, transient: true},
+		{err: certErr},
+		{err: ErrRangeNotSupported},
+		{err: context.Canceled},
+	}
+
+	for _, c := range cases {
+		if IsNotFound(c.err) != c.notFound || IsUnauthorized(c.err) != c.unauthorized ||
+			IsRateLimited(c.err) != c.rateLimited || IsTransient(c.err) != c.transient ||
+			IsRetryable(c.err) != (c.transient || c.rateLimited) {
+			t.Errorf("misclassified %v", c.err)
+		}
+	}
+}
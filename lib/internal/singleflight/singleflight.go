@@ -0,0 +1,59 @@
+// Package singleflight coalesces concurrent calls for the same key.
+package singleflight
+
+import (
+	"context"
+	"sync"
+)
+
+type call[T any] struct {
+	done  chan struct{}
+	value T
+	err   error
+}
+
+// Group runs at most one function per key at a time. Callers arriving while
+// a call is in flight wait for it and share its result.
+type Group[T any] struct {
+	calls map[string]*call[T]
+	mutex sync.Mutex
+}
+
+// Do runs fn for key unless a call for key is already in flight. fn gets a
+// context that is not cancelled with ctx, as other callers may depend on its
+// result; a cancelled ctx only stops this caller from waiting. shared
+// reports whether the result was handed to more than one caller.
+func (g *Group[T]) Do(ctx context.Context, key string, fn func(ctx context.Context) (T, error)) (value T, err error, shared bool) {
+	g.mutex.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call[T])
+	}
+
+	c, inFlight := g.calls[key]
+	if !inFlight {
+		c = &call[T]{done: make(chan struct{})}
+		g.calls[key] = c
+
+		go g.run(ctx, key, c, fn)
+	}
+	g.mutex.Unlock()
+
+	select {
+	case <-c.done:
+		return c.value, c.err, inFlight
+	case <-ctx.Done():
+		return value, ctx.Err(), inFlight
+	}
+}
+
+func (g *Group[T]) run(ctx context.Context, key string, c *call[T], fn func(ctx context.Context) (T, error)) {
+	defer func() {
+		g.mutex.Lock()
+		delete(g.calls, key)
+		g.mutex.Unlock()
+
+		close(c.done)
+	}()
+
+	c.value, c.err = fn(context.WithoutCancel(ctx))
+}
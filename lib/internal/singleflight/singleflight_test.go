@@ -0,0 +1,71 @@
+package singleflight
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDoCoalescesConcurrentCalls(t *testing.T) {
+	var group Group[int]
+	var calls atomic.Int32
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	results := make([]int, 10)
+
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			value, err, _ := group.Do(context.Background(), "key", func(context.Context) (int, error) {
+				calls.Add(1)
+				<-release
+				return 42, nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+			results[i] = value
+		}()
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Fatalf("expected a single call, got %d", calls.Load())
+	}
+
+	for _, value := range results {
+		if value != 42 {
+			t.Fatalf("unexpected shared result %d", value)
+		}
+	}
+
+	if value, _, _ := group.Do(context.Background(), "key", func(context.Context) (int, error) { return 7, nil }); value != 7 {
+		t.Fatal("completed calls must not be reused")
+	}
+}
+
+func TestDoWaiterCancellation(t *testing.T) {
+	var group Group[int]
+	release := make(chan struct{})
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err, _ := group.Do(ctx, "key", func(ctx context.Context) (int, error) {
+		<-release
+		return 0, ctx.Err()
+	})
+
+	if err != context.Canceled {
+		t.Fatalf("expected the waiter to observe its cancellation, got %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package recorder
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"unicode/utf8"
+)
+
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   Body        `json:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       Body        `json:"body,omitempty"`
+}
+
+// Body is stored verbatim when it is valid UTF-8 and base64 encoded otherwise,
+// so that cassettes of JSON exchanges stay readable and diffable.
+type Body []byte
+
+type encodedBody struct {
+	Encoding string `json:"encoding"`
+	Data     string `json:"data"`
+}
+
+func (b Body) MarshalJSON() ([]byte, error) {
+	if utf8.Valid(b) {
+		return json.Marshal(string(b))
+	}
+
+	return json.Marshal(encodedBody{
+		Encoding: "base64",
+		Data:     base64.StdEncoding.EncodeToString(b),
+	})
+}
+
+func (b *Body) UnmarshalJSON(data []byte) (err error) {
+	var plain string
+	if err = json.Unmarshal(data, &plain); err == nil {
+		*b = Body(plain)
+		return
+	}
+
+	var encoded encodedBody
+	if err = json.Unmarshal(data, &encoded); err != nil {
+		return
+	}
+
+	*b, err = base64.StdEncoding.DecodeString(encoded.Data)
+	return
+}
+
+func LoadCassette(path string) (cassette *Cassette, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	cassette = new(Cassette)
+	err = json.Unmarshal(data, cassette)
+
+	return
+}
+
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
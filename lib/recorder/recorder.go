@@ -0,0 +1,214 @@
+package recorder
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+)
+
+type Mode int
+
+const (
+	// ModeRecord forwards requests to the real transport and records them.
+	ModeRecord Mode = iota
+	// ModeReplay serves responses from the cassette and never touches the network.
+	ModeReplay
+)
+
+var defaultMatchHeaders = []string{"Accept", "Range"}
+
+// Recorder is an http.RoundTripper that records registry and auth server
+// exchanges into a cassette file or replays them from it. Plug it in with
+// Config.SetHttpClient(recorder.Client()).
+type Recorder struct {
+	mode         Mode
+	path         string
+	transport    http.RoundTripper
+	matchHeaders []string
+	cassette     *Cassette
+	used         []bool
+	mutex        sync.Mutex
+}
+
+type NoInteractionError string
+
+func (e NoInteractionError) Error() string {
+	return string(e)
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	if r.mode == ModeRecord {
+		return r.record(req)
+	}
+
+	return r.replay(req)
+}
+
+// SetTransport overrides the transport used in record mode.
+func (r *Recorder) SetTransport(transport http.RoundTripper) {
+	r.transport = transport
+}
+
+// SetMatchHeaders selects the request headers that must match during replay
+// in addition to method, path and query.
+func (r *Recorder) SetMatchHeaders(headers ...string) {
+	r.matchHeaders = headers
+}
+
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{
+		Transport: r,
+	}
+}
+
+// Save writes the recorded interactions to the cassette file.
+func (r *Recorder) Save() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.cassette.Save(r.path)
+}
+
+func (r *Recorder) record(req *http.Request) (response *http.Response, err error) {
+	var requestBody []byte
+	if req.Body != nil {
+		requestBody, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return
+		}
+
+		req.Body = io.NopCloser(bytes.NewReader(requestBody))
+	}
+
+	response, err = r.transport.RoundTrip(req)
+	if err != nil {
+		return
+	}
+
+	responseBody, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return
+	}
+	response.Body = io.NopCloser(bytes.NewReader(responseBody))
+
+	interaction := Interaction{
+		Request: RecordedRequest{
+			Method: req.Method,
+			URL:    redactUrl(req.URL.String()),
+			Header: redactHeader(req.Header),
+			Body:   redactBody(requestBody, req.Header.Get("Content-Type")),
+		},
+		Response: RecordedResponse{
+			StatusCode: response.StatusCode,
+			Header:     redactHeader(response.Header),
+			Body:       redactBody(responseBody, response.Header.Get("Content-Type")),
+		},
+	}
+
+	r.mutex.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	r.used = append(r.used, true)
+	r.mutex.Unlock()
+
+	return
+}
+
+func (r *Recorder) replay(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// Interactions are consumed in recording order; once all matching ones have
+	// been used the last one keeps being served, so that retries and repeated
+	// calls remain deterministic.
+	match := -1
+	for i := range r.cassette.Interactions {
+		if !r.matches(&r.cassette.Interactions[i].Request, req) {
+			continue
+		}
+
+		match = i
+		if !r.used[i] {
+			break
+		}
+	}
+
+	if match == -1 {
+		return nil, NoInteractionError(fmt.Sprintf("no recorded interaction for %s %s", req.Method, redactUrl(req.URL.String())))
+	}
+
+	r.used[match] = true
+	recorded := r.cassette.Interactions[match].Response
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+		StatusCode:    recorded.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        recorded.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(recorded.Body)),
+		ContentLength: int64(len(recorded.Body)),
+		Request:       req,
+	}, nil
+}
+
+func (r *Recorder) matches(recorded *RecordedRequest, req *http.Request) bool {
+	if recorded.Method != req.Method {
+		return false
+	}
+
+	recordedUrl, err := url.Parse(recorded.URL)
+	if err != nil {
+		return false
+	}
+
+	if recordedUrl.Path != req.URL.Path ||
+		recordedUrl.Query().Encode() != redactQuery(req.URL.Query()).Encode() {
+		return false
+	}
+
+	header := redactHeader(req.Header)
+	for _, name := range r.matchHeaders {
+		if recorded.Header.Get(name) != header.Get(name) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// New creates a recorder backed by the cassette at path. In replay mode the
+// cassette must exist; in record mode it is created or overwritten on Save.
+func New(path string, mode Mode) (recorder *Recorder, err error) {
+	recorder = &Recorder{
+		mode:         mode,
+		path:         path,
+		transport:    http.DefaultTransport,
+		matchHeaders: defaultMatchHeaders,
+		cassette:     new(Cassette),
+	}
+
+	if mode == ModeReplay {
+		recorder.cassette, err = LoadCassette(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				err = fmt.Errorf("cassette %s does not exist; record it first", path)
+			}
+			return nil, err
+		}
+
+		recorder.used = make([]bool, len(recorder.cassette.Interactions))
+	}
+
+	return
+}
@@ -0,0 +1,118 @@
+package recorder
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kspeeder/docker-registry/lib"
+)
+
+const secretToken = "s3cr3t-token"
+
+func newTokenServer() *httptest.Server {
+	var server *httptest.Server
+
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"token":"%s"}`, secretToken)
+
+		case r.Header.Get("Authorization") != "Bearer "+secretToken:
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+				`Bearer realm="%s/token",service="test",scope="repository:foo/bar:pull"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+
+		default:
+			fmt.Fprint(w, `{"name":"foo/bar","tags":["1.0","latest"]}`)
+		}
+	}))
+
+	return server
+}
+
+func listTags(t *testing.T, registryUrl string, client *http.Client) []string {
+	parsedUrl, _ := url.Parse(registryUrl)
+
+	cfg := lib.NewConfig()
+	cfg.SetUrl(*parsedUrl)
+	cfg.SetCredentials(lib.NewRegistryCredentials("user", "password"))
+	cfg.SetHttpClient(client)
+
+	api, err := lib.NewRegistryApi(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := api.ListTags("foo/bar")
+	tags := []string{}
+	for tag := range response.Tags() {
+		tags = append(tags, tag.Name())
+	}
+
+	if err := response.LastError(); err != nil {
+		t.Fatal(err)
+	}
+
+	return tags
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	cassettePath := filepath.Join(t.TempDir(), "tags.json")
+
+	server := newTokenServer()
+	recorder, err := New(cassettePath, ModeRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorded := listTags(t, server.URL, recorder.Client())
+	server.Close()
+
+	if err := recorder.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(cassettePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(data), secretToken) {
+		t.Fatal("cassette leaks the bearer token")
+	}
+
+	replayer, err := New(cassettePath, ModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replayed := listTags(t, server.URL, replayer.Client())
+
+	if strings.Join(recorded, ",") != "1.0,latest" || strings.Join(replayed, ",") != strings.Join(recorded, ",") {
+		t.Fatalf("replay diverged from recording; recorded %v, replayed %v", recorded, replayed)
+	}
+}
+
+func TestReplayUnknownRequest(t *testing.T) {
+	cassettePath := filepath.Join(t.TempDir(), "empty.json")
+	if err := new(Cassette).Save(cassettePath); err != nil {
+		t.Fatal(err)
+	}
+
+	replayer, err := New(cassettePath, ModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = replayer.Client().Get("http://registry.invalid/v2/")
+	if err == nil {
+		t.Fatal("replaying an unrecorded request should fail")
+	}
+}
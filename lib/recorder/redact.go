@@ -0,0 +1,112 @@
+package recorder
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const redacted = "REDACTED"
+
+var sensitiveHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+}
+
+// Form fields and JSON keys carrying secrets in auth server exchanges.
+var sensitiveFields = []string{
+	"password",
+	"token",
+	"access_token",
+	"refresh_token",
+	"id_token",
+}
+
+// Query parameters carrying signatures in presigned storage URLs.
+var sensitiveQueryParams = []string{
+	"X-Amz-Signature",
+	"X-Amz-Credential",
+	"X-Amz-Security-Token",
+	"Signature",
+	"sig",
+}
+
+func redactHeader(header http.Header) http.Header {
+	redactedHeader := header.Clone()
+
+	for _, name := range sensitiveHeaders {
+		if _, ok := redactedHeader[http.CanonicalHeaderKey(name)]; ok {
+			redactedHeader.Set(name, redacted)
+		}
+	}
+
+	return redactedHeader
+}
+
+func redactUrl(rawUrl string) string {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return rawUrl
+	}
+
+	parsed.User = nil
+	parsed.RawQuery = redactQuery(parsed.Query()).Encode()
+
+	return parsed.String()
+}
+
+func redactQuery(query url.Values) url.Values {
+	for _, name := range sensitiveQueryParams {
+		if _, ok := query[name]; ok {
+			query.Set(name, redacted)
+		}
+	}
+
+	return query
+}
+
+func redactBody(body []byte, contentType string) []byte {
+	switch {
+	case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return body
+		}
+
+		for _, field := range sensitiveFields {
+			if _, ok := form[field]; ok {
+				form.Set(field, redacted)
+			}
+		}
+
+		return []byte(form.Encode())
+
+	default:
+		var document map[string]interface{}
+		if json.Unmarshal(body, &document) != nil {
+			return body
+		}
+
+		changed := false
+		for _, field := range sensitiveFields {
+			if _, ok := document[field]; ok {
+				document[field] = redacted
+				changed = true
+			}
+		}
+
+		if !changed {
+			return body
+		}
+
+		redactedBody, err := json.Marshal(document)
+		if err != nil {
+			return body
+		}
+
+		return redactedBody
+	}
+}
@@ -1,16 +1,25 @@
 package lib
 
 import (
+	"context"
 	"net/http"
 	"net/url"
 	"strconv"
+	"sync"
+	"time"
 
+	"github.com/kspeeder/docker-registry/lib/auth"
 	"github.com/kspeeder/docker-registry/lib/connector"
 )
 
 type registryApi struct {
-	cfg       Config
-	connector connector.Connector
+	cfg          Config
+	connector    connector.Connector
+	capabilities map[string]*Capabilities
+	// Hosts that answered range requests with full responses.
+	unsupportedRanges map[string]time.Time
+	capabilitiesMutex sync.Mutex
+	blobRedirects     *blobRedirectCache
 }
 
 func (r *registryApi) endpointUrl(path string) *url.URL {
@@ -55,6 +64,21 @@ func (r *registryApi) paginatedRequestEndpointUrl(path string, lastApiResponse *
 	return
 }
 
+func (r *registryApi) requestContext(ctx context.Context, operation connector.Operation, repository string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// Keep scopes requested by the caller.
+	info, _ := connector.RequestInfoFromContext(ctx)
+
+	return connector.WithRequestInfo(ctx, connector.RequestInfo{
+		Operation:  operation,
+		Repository: repository,
+		Scopes:     info.Scopes,
+	})
+}
+
 func (r *registryApi) pageSize() uint {
 	return r.cfg.pageSize
 }
@@ -63,18 +87,43 @@ func (r *registryApi) GetStatistics() connector.Statistics {
 	return r.connector.GetStatistics()
 }
 
+// SetCredentials replaces the credentials of the API; credential providers of
+// the config and the docker config are still asked if they are blank.
+func (r *registryApi) SetCredentials(credentials RegistryCredentials) {
+	r.SetCredentialProvider(r.cfg.credentialChain(&credentials))
+}
+
+// SetCredentialProvider replaces the credential provider of the API and drops
+// all tokens and storage URLs obtained with the previous credentials. Requests
+// in flight finish with the tokens they hold.
+func (r *registryApi) SetCredentialProvider(provider auth.CredentialProvider) {
+	r.cfg.credentialSwitch.Set(provider)
+	r.blobRedirects.Clear()
+	if invalidator, ok := r.connector.(connector.TokenInvalidator); ok {
+		invalidator.InvalidateTokens()
+	}
+}
+
 func NewRegistryApi(cfg Config) (api RegistryApi, err error) {
 	err = cfg.Validate()
 	if err != nil {
 		return
 	}
 
-	cfg.LoadCredentialsFromDockerConfig()
+	// The docker config is the fallback of explicit credentials and providers.
+	cfg.dockerConfigProvider = NewDockerConfigCredentialProvider()
 
 	registry := &registryApi{
-		cfg: cfg,
+		cfg:               cfg,
+		capabilities:      make(map[string]*Capabilities),
+		unsupportedRanges: make(map[string]time.Time),
+	}
+
+	if cfg.cacheBlobRedirects {
+		registry.blobRedirects = newBlobRedirectCache(cfg.blobRedirectTTL)
 	}
 
+	registry.cfg.credentialSwitch = newCredentialSwitch(cfg.CredentialProvider())
 	registry.connector = createConnector(&registry.cfg)
 
 	api = registry
@@ -0,0 +1,722 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kspeeder/docker-registry/lib/auth"
+	"github.com/kspeeder/docker-registry/lib/connector"
+	"github.com/kspeeder/docker-registry/lib/registrytest"
+)
+
+func newTestRegistry(auth registrytest.AuthMode) *registrytest.Registry {
+	return registrytest.New(registrytest.Options{
+		Auth: auth,
+		Users: map[string]string{
+			"user": "password",
+		},
+	})
+}
+
+func newTestApi(t *testing.T, registry *registrytest.Registry, configure func(*Config)) RegistryApi {
+	cfg := NewConfig()
+	cfg.SetUrl(*registry.URL())
+	cfg.SetCredentials(NewRegistryCredentials("user", "password"))
+	cfg.SetPagesize(2)
+
+	if configure != nil {
+		configure(&cfg)
+	}
+
+	api, err := NewRegistryApi(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return api
+}
+
+func collectTags(t *testing.T, api RegistryApi, repository string) (tags []string) {
+	response := api.ListTags(repository)
+	for tag := range response.Tags() {
+		tags = append(tags, tag.Name())
+	}
+
+	if err := response.LastError(); err != nil {
+		t.Fatal(err)
+	}
+
+	return
+}
+
+func TestTokenAuthPaginatedTagList(t *testing.T) {
+	registry := newTestRegistry(registrytest.AuthToken)
+	defer registry.Close()
+
+	for _, tag := range []string{"a", "b", "c", "d", "e"} {
+		registry.AddImage("org/team/app", tag, []byte(tag))
+	}
+
+	api := newTestApi(t, registry, nil)
+
+	if tags := collectTags(t, api, "org/team/app"); !reflect.DeepEqual(tags, []string{"a", "b", "c", "d", "e"}) {
+		t.Fatalf("unexpected tags %v", tags)
+	}
+
+	if fetches := registry.TokenRequests(); fetches != 1 {
+		t.Fatalf("expected a single token fetch across pages, got %d", fetches)
+	}
+}
+
+func TestTokenAuthRecoversFromStaleToken(t *testing.T) {
+	registry := newTestRegistry(registrytest.AuthToken)
+	defer registry.Close()
+
+	registry.AddImage("foo", "latest", []byte("layer"))
+	api := newTestApi(t, registry, nil)
+
+	collectTags(t, api, "foo")
+	registry.RevokeTokens()
+
+	if tags := collectTags(t, api, "foo"); !reflect.DeepEqual(tags, []string{"latest"}) {
+		t.Fatalf("unexpected tags %v", tags)
+	}
+
+	if fetches := registry.TokenRequests(); fetches != 2 {
+		t.Fatalf("expected the stale token to be replaced, got %d token fetches", fetches)
+	}
+}
+
+func TestListRepositories(t *testing.T) {
+	registry := newTestRegistry(registrytest.AuthToken)
+	defer registry.Close()
+
+	for _, name := range []string{"a/x", "b", "c/y/z"} {
+		registry.AddImage(name, "latest", []byte(name))
+	}
+
+	api := newTestApi(t, registry, nil)
+	response := api.ListRepositories()
+
+	var names []string
+	for repository := range response.Repositories() {
+		names = append(names, repository.Name())
+	}
+
+	if err := response.LastError(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(names, []string{"a/x", "b", "c/y/z"}) {
+		t.Fatalf("unexpected repositories %v", names)
+	}
+}
+
+func TestRangeBlobs(t *testing.T) {
+	registry := newTestRegistry(registrytest.AuthToken)
+	defer registry.Close()
+
+	content := []byte("0123456789abcdef")
+	_, layers := registry.AddImage("foo", "latest", content)
+	api := newTestApi(t, registry, nil)
+	ref := NewRefspec("foo", "latest")
+
+	response, err := api.RangeBlobs(context.Background(), ref, 2, layers[0], 4, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, content[4:10]) {
+		t.Fatalf("unexpected range content %q", data)
+	}
+
+	registry.SetFaults(registrytest.Faults{IgnoreRange: true})
+
+	if _, err := api.RangeBlobs(context.Background(), ref, 2, layers[0], 4, 10, nil); err == nil {
+		t.Fatal("a full response to a range request should fail")
+	}
+}
+
+func TestBasicAuthTagDetailsAndDelete(t *testing.T) {
+	registry := newTestRegistry(registrytest.AuthBasic)
+	defer registry.Close()
+
+	manifestDigest, layers := registry.AddImage("foo", "latest", []byte("one"), []byte("two"))
+	api := newTestApi(t, registry, func(cfg *Config) {
+		cfg.SetUseBasicAuth(true)
+	})
+
+	details, err := api.GetTagDetails(context.Background(), NewRefspec("foo", "latest"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if details.ContentDigest() != manifestDigest || len(details.Layers()) != 2 || details.Layers()[1].ContentDigest() != layers[1] {
+		t.Fatalf("unexpected tag details %+v", details)
+	}
+
+	if err := api.DeleteTag(NewRefspec("foo", manifestDigest)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := api.GetTagDetails(context.Background(), NewRefspec("foo", "latest"), 2); err == nil {
+		t.Fatal("deleted tag should be gone")
+	}
+}
+
+func TestAutoAuthDetectsScheme(t *testing.T) {
+	for _, mode := range []registrytest.AuthMode{registrytest.AuthNone, registrytest.AuthBasic, registrytest.AuthToken} {
+		registry := newTestRegistry(mode)
+		registry.AddImage("foo", "latest", []byte("layer"))
+
+		api := newTestApi(t, registry, func(cfg *Config) {
+			cfg.SetUseAutoAuth(true)
+		})
+
+		if tags := collectTags(t, api, "foo"); !reflect.DeepEqual(tags, []string{"latest"}) {
+			t.Fatalf("auth mode %d: unexpected tags %v", mode, tags)
+		}
+
+		registry.Close()
+	}
+}
+
+func TestTokenAuthAgainstBasicRegistry(t *testing.T) {
+	registry := newTestRegistry(registrytest.AuthBasic)
+	defer registry.Close()
+
+	registry.AddImage("foo", "latest", []byte("layer"))
+	api := newTestApi(t, registry, nil)
+
+	response := api.ListTags("foo")
+	for range response.Tags() {
+	}
+
+	if err, ok := response.LastError().(*connector.ChallengeError); !ok || !strings.Contains(err.Error(), "Basic") {
+		t.Fatalf("expected a challenge error naming the basic scheme, got %v", response.LastError())
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	registry := registrytest.New(registrytest.Options{
+		Auth:           registrytest.AuthToken,
+		Users:          map[string]string{"user": "password"},
+		DisableCatalog: true,
+		DisableDelete:  true,
+		Referrers:      true,
+		ChunkMinLength: 1024,
+	})
+	defer registry.Close()
+
+	registry.AddImage("foo", "latest", []byte("layer"))
+	registry.AddImage("source", "latest", []byte("source layer"))
+	api := newTestApi(t, registry, nil)
+
+	capabilities, err := api.Capabilities(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if capabilities.ApiVersion != "registry/2.0" || capabilities.Catalog != CapabilityUnsupported || capabilities.RangeRequests != CapabilityUnknown {
+		t.Fatalf("unexpected registry capabilities %+v", capabilities)
+	}
+
+	capabilities, err = api.Capabilities(context.Background(), NewRefspec("foo", "latest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if capabilities.Referrers != CapabilitySupported ||
+		capabilities.Delete != CapabilityUnknown ||
+		capabilities.RangeRequests != CapabilitySupported ||
+		capabilities.CrossRepositoryMount != CapabilityUnknown {
+		t.Fatalf("unexpected repository capabilities %+v", capabilities)
+	}
+
+	requests := registry.Requests()
+	if _, err := api.Capabilities(context.Background(), NewRefspec("foo", "latest")); err != nil || registry.Requests() != requests {
+		t.Fatalf("capabilities should be served from the cache; err %v", err)
+	}
+
+	api = newTestApi(t, registry, func(cfg *Config) {
+		cfg.SetProbeWrites(true)
+		cfg.SetMountProbeSource(NewRefspec("source", "latest"))
+	})
+
+	capabilities, err = api.Capabilities(context.Background(), NewRefspec("foo", "latest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if capabilities.Delete != CapabilityUnsupported ||
+		capabilities.CrossRepositoryMount != CapabilitySupported ||
+		capabilities.ChunkMinLength != 1024 {
+		t.Fatalf("unexpected write capabilities %+v", capabilities)
+	}
+}
+
+func TestCombinedScopesShareToken(t *testing.T) {
+	registry := newTestRegistry(registrytest.AuthToken)
+	defer registry.Close()
+
+	registry.AddImage("source", "latest", []byte("layer"))
+	registry.AddImage("org/team/target", "latest", []byte("layer"))
+	api := newTestApi(t, registry, nil)
+
+	ctx := connector.WithScopes(context.Background(),
+		auth.RepositoryScope("source", auth.ActionPull).String(),
+		auth.RepositoryScope("org/team/target", auth.ActionPull, auth.ActionPush).String(),
+	)
+
+	for _, repository := range []string{"source", "org/team/target"} {
+		if _, err := api.GetTagDetails(ctx, NewRefspec(repository, "latest"), 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if fetches := registry.TokenRequests(); fetches != 1 {
+		t.Fatalf("expected one token covering both repositories, got %d token fetches", fetches)
+	}
+}
+
+func TestSetCredentialsInvalidatesTokens(t *testing.T) {
+	users := map[string]string{"user": "password"}
+	registry := registrytest.New(registrytest.Options{
+		Auth:  registrytest.AuthToken,
+		Users: users,
+	})
+	defer registry.Close()
+
+	registry.AddImage("foo", "latest", []byte("layer"))
+	api := newTestApi(t, registry, nil)
+
+	collectTags(t, api, "foo")
+
+	users["user"] = "rotated"
+	api.SetCredentials(NewRegistryCredentials("user", "rotated"))
+
+	if tags := collectTags(t, api, "foo"); !reflect.DeepEqual(tags, []string{"latest"}) {
+		t.Fatalf("unexpected tags %v", tags)
+	}
+
+	if fetches := registry.TokenRequests(); fetches != 2 {
+		t.Fatalf("expected a new token after rotating credentials, got %d token fetches", fetches)
+	}
+}
+
+func TestDockerConfigIsLastResort(t *testing.T) {
+	registry := newTestRegistry(registrytest.AuthToken)
+	defer registry.Close()
+	registry.AddImage("foo", "latest", []byte("layer"))
+
+	dir := t.TempDir()
+	dockerConfig := `{"auths": {"` + registry.URL().Host + `": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("user:wrong")) + `"}}}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(dockerConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DOCKER_CONFIG", dir)
+
+	robot := NewRegistryCredentials("user", "password")
+	api := newTestApi(t, registry, func(cfg *Config) {
+		cfg.SetCredentials(NewRegistryCredentials("", ""))
+		cfg.AddCredentialProvider(NewScopedCredentialProvider(registry.URL().Host+"/foo", &robot))
+	})
+
+	if tags := collectTags(t, api, "foo"); !reflect.DeepEqual(tags, []string{"latest"}) {
+		t.Fatalf("unexpected tags %v", tags)
+	}
+}
+
+func TestCheckAccess(t *testing.T) {
+	// The anonymous checks need a docker config without credentials.
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	for _, mode := range []registrytest.AuthMode{registrytest.AuthToken, registrytest.AuthBasic} {
+		registry := registrytest.New(registrytest.Options{
+			Auth:          mode,
+			Users:         map[string]string{"user": "password"},
+			AnonymousPull: true,
+		})
+		registry.AddImage("foo", "latest", []byte("layer"))
+
+		api := newTestApi(t, registry, func(cfg *Config) {
+			cfg.SetUseAutoAuth(true)
+			cfg.SetProbeWrites(true)
+		})
+
+		report, err := api.CheckAccess(context.Background(), "foo", auth.ActionPull, auth.ActionPush, auth.ActionDelete)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !report.Allowed() || report.Probed != (mode == registrytest.AuthBasic) {
+			t.Fatalf("auth mode %d: unexpected report %+v", mode, report)
+		}
+
+		api.SetCredentials(NewRegistryCredentials("", ""))
+
+		report, err = api.CheckAccess(context.Background(), "foo", auth.ActionPull, auth.ActionPush)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(report.Granted, []string{auth.ActionPull}) || !reflect.DeepEqual(report.Denied, []string{auth.ActionPush}) {
+			t.Fatalf("auth mode %d: unexpected anonymous report %+v", mode, report)
+		}
+
+		if _, ok := report.Err().(AutorizationError); !ok {
+			t.Fatalf("auth mode %d: expected an authorization error, got %v", mode, report.Err())
+		}
+
+		registry.Close()
+	}
+}
+
+func TestCheckAccessReusesPing(t *testing.T) {
+	registry := newTestRegistry(registrytest.AuthToken)
+	defer registry.Close()
+	registry.AddImage("foo", "latest", []byte("layer"))
+
+	api := newTestApi(t, registry, func(cfg *Config) {
+		cfg.SetUseAutoAuth(true)
+	})
+
+	if _, err := api.CheckAccess(context.Background(), "foo", auth.ActionPull); err != nil {
+		t.Fatal(err)
+	}
+
+	if requests := registry.Requests(); requests != 1 {
+		t.Fatalf("expected the scheme detection ping to be reused, got %d registry requests", requests)
+	}
+}
+
+func TestCheckAccessUndetermined(t *testing.T) {
+	registry := registrytest.New(registrytest.Options{
+		Auth:          registrytest.AuthBasic,
+		Users:         map[string]string{"user": "password"},
+		DisableDelete: true,
+	})
+	defer registry.Close()
+	registry.AddImage("foo", "latest", []byte("layer"))
+
+	api := newTestApi(t, registry, func(cfg *Config) {
+		cfg.SetUseAutoAuth(true)
+	})
+
+	for _, test := range []struct {
+		repository, action string
+	}{
+		{"missing", auth.ActionPull},
+		{"foo", auth.ActionDelete},
+		{"foo", auth.ActionPush},
+	} {
+		requests := registry.Requests()
+		report, err := api.CheckAccess(context.Background(), test.repository, test.action)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(report.Unknown, []string{test.action}) || len(report.Granted) != 0 || len(report.Denied) != 0 {
+			t.Fatalf("%s on %s: expected an undetermined report, got %+v", test.action, test.repository, report)
+		}
+
+		if test.action == auth.ActionPush && registry.Requests() != requests {
+			t.Fatal("push should not be probed without write probes")
+		}
+
+		if report.Allowed() || !strings.Contains(report.Err().Error(), "could not be determined") {
+			t.Fatalf("%s on %s: unexpected error %v", test.action, test.repository, report.Err())
+		}
+	}
+}
+
+func TestAnonymousFallback(t *testing.T) {
+	registry := registrytest.New(registrytest.Options{
+		Auth:          registrytest.AuthToken,
+		Users:         map[string]string{"user": "password"},
+		AnonymousPull: true,
+	})
+	defer registry.Close()
+
+	registry.AddImage("foo", "latest", []byte("layer"))
+
+	var warnings []string
+	api := newTestApi(t, registry, func(cfg *Config) {
+		cfg.SetCredentials(NewRegistryCredentials("user", "expired"))
+		cfg.SetAnonymousFallback(true)
+		cfg.SetAnonymousFallbackHandler(func(account string, scopes []string, rejection error) {
+			warnings = append(warnings, account+" "+strings.Join(scopes, " "))
+		})
+	})
+
+	if tags := collectTags(t, api, "foo"); !reflect.DeepEqual(tags, []string{"latest"}) {
+		t.Fatalf("unexpected tags %v", tags)
+	}
+
+	if !reflect.DeepEqual(warnings, []string{"user repository:foo:pull"}) || api.GetStatistics().AnonymousFallbacks() != 1 {
+		t.Fatalf("unexpected warnings %v", warnings)
+	}
+
+	if _, err := api.CheckAccess(context.Background(), "foo", auth.ActionPush); err == nil {
+		t.Fatal("push scopes must not fall back to anonymous tokens")
+	}
+}
+
+func TestRedirectedBlobsDoNotLeakCredentials(t *testing.T) {
+	for _, mode := range []registrytest.AuthMode{registrytest.AuthToken, registrytest.AuthBasic} {
+		registry := registrytest.New(registrytest.Options{
+			Auth:          mode,
+			Users:         map[string]string{"user": "password"},
+			RedirectBlobs: true,
+		})
+		_, layers := registry.AddImage("foo", "latest", []byte("layer"))
+
+		api := newTestApi(t, registry, func(cfg *Config) {
+			cfg.SetUseBasicAuth(mode == registrytest.AuthBasic)
+		})
+
+		blob, err := api.GetBlobs(context.Background(), NewRefspec("foo", "latest"), 2, layers[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(blob)
+		blob.Close()
+
+		if string(content) != "layer" || registry.StorageRequests() != 1 || api.GetStatistics().Redirects() != 1 {
+			t.Fatalf("auth mode %d: blob was not fetched through one redirect", mode)
+		}
+
+		if registry.StorageAuthorizations() != 0 {
+			t.Fatalf("auth mode %d: credentials were sent to the storage host", mode)
+		}
+
+		blobUrl, err := api.BlobURL(context.Background(), NewRefspec("foo", "latest"), layers[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if blobUrl.Host == registry.URL().Host || !strings.HasSuffix(blobUrl.Path, layers[0]) || registry.StorageRequests() != 1 {
+			t.Fatalf("auth mode %d: unexpected blob URL %v", mode, blobUrl)
+		}
+
+		registry.Close()
+	}
+}
+
+type statusRecorder struct {
+	statuses []int
+}
+
+func (m *statusRecorder) Before(request *http.Request, info connector.RequestInfo) *http.Request {
+	return request
+}
+
+func (m *statusRecorder) After(request *http.Request, info connector.RequestInfo, response *http.Response, err error) {
+	if response != nil {
+		m.statuses = append(m.statuses, response.StatusCode)
+	}
+}
+
+func TestBlobURLWithoutRedirect(t *testing.T) {
+	registry := newTestRegistry(registrytest.AuthBasic)
+	defer registry.Close()
+	_, layers := registry.AddImage("foo", "latest", []byte("layer"))
+
+	recorder := &statusRecorder{}
+	api := newTestApi(t, registry, func(cfg *Config) {
+		cfg.SetUseAutoAuth(true)
+		cfg.AddMiddleware(recorder)
+	})
+
+	blobUrl, err := api.BlobURL(context.Background(), NewRefspec("foo", "latest"), layers[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if blobUrl.Host != registry.URL().Host || !strings.HasSuffix(blobUrl.Path, layers[0]) {
+		t.Fatalf("expected the registry URL of the blob, got %v", blobUrl)
+	}
+
+	if last := recorder.statuses[len(recorder.statuses)-1]; last != http.StatusPartialContent {
+		t.Fatalf("expected only the first byte to be requested, got status %d", last)
+	}
+}
+
+type storageHeaderRecorder struct {
+	registryHost string
+	leaked       []string
+}
+
+func (m *storageHeaderRecorder) Before(request *http.Request, info connector.RequestInfo) *http.Request {
+	if request.URL.Host != m.registryHost && request.Header.Get("X-Registry-Only") != "" {
+		m.leaked = append(m.leaked, request.URL.String())
+	}
+	return request
+}
+
+func (m *storageHeaderRecorder) After(request *http.Request, info connector.RequestInfo, response *http.Response, err error) {
+}
+
+func TestRangeBlobsUseCachedStorageURL(t *testing.T) {
+	registry := registrytest.New(registrytest.Options{
+		Auth:             registrytest.AuthToken,
+		Users:            map[string]string{"user": "password"},
+		RedirectBlobs:    true,
+		PresignExpiresIn: 3600,
+	})
+	defer registry.Close()
+
+	_, layers := registry.AddImage("foo", "latest", []byte("0123456789"))
+	recorder := &storageHeaderRecorder{registryHost: registry.URL().Host}
+	api := newTestApi(t, registry, func(cfg *Config) {
+		cfg.SetCacheBlobRedirects(true)
+		cfg.AddMiddleware(recorder)
+	})
+
+	readRange := func(start, end int64) string {
+		response, err := api.RangeBlobs(context.Background(), NewRefspec("foo", "latest"), 2, layers[0], start, end,
+			map[string]string{"X-Registry-Only": "true"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+
+		content, _ := io.ReadAll(response.Body)
+		return string(content)
+	}
+
+	if readRange(0, 4) != "0123" {
+		t.Fatal("unexpected first range")
+	}
+	registryRequests := registry.Requests()
+	// Only requests sent straight to the cached URL are of interest.
+	recorder.leaked = nil
+
+	if readRange(4, 8) != "4567" || registry.Requests() != registryRequests || registry.StorageRequests() != 2 {
+		t.Fatalf("second range should go straight to storage, registry requests %d -> %d", registryRequests, registry.Requests())
+	}
+
+	if len(recorder.leaked) != 0 {
+		t.Fatalf("caller headers were sent to the storage host: %v", recorder.leaked)
+	}
+
+	// A canceled request says nothing about the storage URL.
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := api.RangeBlobs(canceled, NewRefspec("foo", "latest"), 2, layers[0], 0, 4, nil); err == nil {
+		t.Fatal("expected a canceled request to fail")
+	}
+
+	if readRange(2, 6) != "2345" || registry.Requests() != registryRequests {
+		t.Fatal("the storage URL should be kept after a canceled request")
+	}
+
+	// Storage URLs were handed out for the previous credentials.
+	api.SetCredentials(NewRegistryCredentials("user", "password"))
+	if readRange(2, 6) != "2345" || registry.Requests() == registryRequests {
+		t.Fatal("changing credentials should drop cached storage URLs")
+	}
+	registryRequests = registry.Requests()
+
+	registry.RevokeStorageURLs()
+
+	if readRange(8, 10) != "89" || registry.Requests() == registryRequests || registry.StorageAuthorizations() != 0 {
+		t.Fatal("rejected storage URLs should fall back to the registry")
+	}
+}
+
+func TestRangeEmulation(t *testing.T) {
+	registry := newTestRegistry(registrytest.AuthToken)
+	defer registry.Close()
+
+	content := []byte("0123456789abcdef")
+	_, layers := registry.AddImage("foo", "latest", content)
+	api := newTestApi(t, registry, func(cfg *Config) {
+		cfg.SetEmulateRanges(true)
+	})
+	ref := NewRefspec("foo", "latest")
+
+	readRange := func(start, end int64) (*http.Response, string) {
+		response, err := api.RangeBlobs(context.Background(), ref, 2, layers[0], start, end, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+
+		data, _ := io.ReadAll(response.Body)
+		return response, string(data)
+	}
+
+	if response, data := readRange(12, -1); data != "cdef" || response.Header.Get(RangeEmulatedHeader) != "" {
+		t.Fatalf("open-ended range should be served by the registry, got %q", data)
+	}
+
+	registry.SetFaults(registrytest.Faults{IgnoreRange: true})
+
+	for i := 0; i < 2; i++ {
+		response, data := readRange(4, 10)
+		if data != "456789" || response.StatusCode != http.StatusPartialContent ||
+			response.Header.Get(RangeEmulatedHeader) != "true" || response.Header.Get("Content-Range") != "bytes 4-9/16" {
+			t.Fatalf("unexpected emulated range %q, headers %v", data, response.Header)
+		}
+	}
+
+	if _, data := readRange(12, -1); data != "cdef" {
+		t.Fatalf("unexpected emulated open-ended range %q", data)
+	}
+}
+
+type userAgentRecorder struct {
+	userAgents []string
+}
+
+func (m *userAgentRecorder) Before(request *http.Request, info connector.RequestInfo) *http.Request {
+	m.userAgents = append(m.userAgents, request.Header.Get("User-Agent"))
+	return request
+}
+
+func (m *userAgentRecorder) After(request *http.Request, info connector.RequestInfo, response *http.Response, err error) {
+}
+
+func TestUserAgentOnlyOnOwnClient(t *testing.T) {
+	registry := newTestRegistry(registrytest.AuthBasic)
+	defer registry.Close()
+
+	registry.AddImage("foo", "latest", []byte("layer"))
+
+	for _, ownClient := range []bool{true, false} {
+		recorder := &userAgentRecorder{}
+		api := newTestApi(t, registry, func(cfg *Config) {
+			cfg.SetUseBasicAuth(true)
+			cfg.SetUserAgent("test-agent")
+			cfg.AddMiddleware(recorder)
+			if !ownClient {
+				cfg.SetHttpClient(registry.Client())
+			}
+		})
+
+		collectTags(t, api, "foo")
+
+		expected := ""
+		if ownClient {
+			expected = "test-agent"
+		}
+		if len(recorder.userAgents) == 0 || recorder.userAgents[0] != expected {
+			t.Fatalf("own client %v: expected user agent %q, got %q", ownClient, expected, recorder.userAgents)
+		}
+	}
+}
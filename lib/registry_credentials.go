@@ -1,11 +1,14 @@
 package lib
 
 import (
+	"context"
 	"flag"
 	"net/url"
 	"os"
 
 	"github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/types"
+	"github.com/kspeeder/docker-registry/lib/auth"
 )
 
 type RegistryCredentials struct {
@@ -39,13 +42,27 @@ func (r *RegistryCredentials) IdentityToken() string {
 	return r.identityToken
 }
 
+// Credentials implements auth.CredentialProvider, handing out the same
+// credentials for all repositories.
+func (r *RegistryCredentials) Credentials(ctx context.Context, registry, repository string) (credentials auth.Credentials, ok bool, err error) {
+	credentials = auth.Credentials{
+		Username:      r.user,
+		Password:      r.password,
+		IdentityToken: r.identityToken,
+	}
+
+	return credentials, !credentials.IsBlank(), nil
+}
+
 func (r *RegistryCredentials) IsBlank() bool {
 	return r.User() == "" && r.Password() == ""
 }
 
 func (r *RegistryCredentials) LoadCredentialsFromDockerConfig(url url.URL) {
+	serverAddress := dockerConfigServerAddress(url.Host)
+
 	dockerConfig := config.LoadDefaultConfigFile(os.Stderr)
-	authConfig, err := dockerConfig.GetCredentialsStore(url.Host).Get(url.Host)
+	authConfig, err := dockerConfig.GetCredentialsStore(serverAddress).Get(serverAddress)
 	if err != nil {
 		return
 	}
@@ -58,6 +75,17 @@ func (r *RegistryCredentials) LoadCredentialsFromDockerConfig(url url.URL) {
 	}
 }
 
+func storeIdentityToken(url url.URL, user, identityToken string) error {
+	serverAddress := dockerConfigServerAddress(url.Host)
+	dockerConfig := config.LoadDefaultConfigFile(os.Stderr)
+
+	return dockerConfig.GetCredentialsStore(serverAddress).Store(types.AuthConfig{
+		ServerAddress: serverAddress,
+		Username:      user,
+		IdentityToken: identityToken,
+	})
+}
+
 func NewRegistryCredentials(user, password string) RegistryCredentials {
 	return RegistryCredentials{
 		user:     user,
@@ -0,0 +1,135 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrorCode is an error code of the distribution spec. Codes are sentinel
+// errors: errors.Is(err, ErrorCodeManifestUnknown) tells if a RegistryError
+// carries the code.
+type ErrorCode string
+
+const (
+	ErrorCodeBlobUnknown         ErrorCode = "BLOB_UNKNOWN"
+	ErrorCodeBlobUploadInvalid   ErrorCode = "BLOB_UPLOAD_INVALID"
+	ErrorCodeBlobUploadUnknown   ErrorCode = "BLOB_UPLOAD_UNKNOWN"
+	ErrorCodeDigestInvalid       ErrorCode = "DIGEST_INVALID"
+	ErrorCodeManifestBlobUnknown ErrorCode = "MANIFEST_BLOB_UNKNOWN"
+	ErrorCodeManifestInvalid     ErrorCode = "MANIFEST_INVALID"
+	ErrorCodeManifestUnknown     ErrorCode = "MANIFEST_UNKNOWN"
+	ErrorCodeNameInvalid         ErrorCode = "NAME_INVALID"
+	ErrorCodeNameUnknown         ErrorCode = "NAME_UNKNOWN"
+	ErrorCodeSizeInvalid         ErrorCode = "SIZE_INVALID"
+	ErrorCodeUnauthorized        ErrorCode = "UNAUTHORIZED"
+	ErrorCodeDenied              ErrorCode = "DENIED"
+	ErrorCodeUnsupported         ErrorCode = "UNSUPPORTED"
+	ErrorCodeTooManyRequests     ErrorCode = "TOOMANYREQUESTS"
+)
+
+func (c ErrorCode) Error() string {
+	return strings.ToLower(strings.ReplaceAll(string(c), "_", " "))
+}
+
+type ErrorDetail struct {
+	Code    ErrorCode       `json:"code"`
+	Message string          `json:"message"`
+	Detail  json.RawMessage `json:"detail,omitempty"`
+}
+
+// RegistryError is returned for responses with unexpected status codes. It
+// unwraps to the plain error returned for the status before, e.g. a
+// NotFoundError.
+type RegistryError struct {
+	StatusCode int
+	Method     string
+	URL        string
+	Errors     []ErrorDetail
+
+	legacy error
+}
+
+// Errors are read from at most this many bytes of the response body.
+const maxErrorBodySize = 64 * 1024
+
+func (e *RegistryError) Error() string {
+	message := e.legacy.Error()
+
+	for i, detail := range e.Errors {
+		separator := ", "
+		if i == 0 {
+			separator = ": "
+		}
+
+		message += separator + string(detail.Code)
+		if detail.Message != "" {
+			message += " (" + detail.Message + ")"
+		}
+	}
+
+	return message
+}
+
+func (e *RegistryError) Unwrap() error {
+	return e.legacy
+}
+
+func (e *RegistryError) Is(target error) bool {
+	code, ok := target.(ErrorCode)
+	return ok && e.HasCode(code)
+}
+
+func (e *RegistryError) HasCode(code ErrorCode) bool {
+	for _, detail := range e.Errors {
+		if detail.Code == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newRegistryError decodes the error list from the response body. Bodies
+// which are no error list are kept in the message of InvalidStatusCodeErrors.
+func newRegistryError(response *http.Response, legacy error) error {
+	registryErr := &RegistryError{
+		StatusCode: response.StatusCode,
+		legacy:     legacy,
+	}
+
+	if response.Request != nil {
+		registryErr.Method = response.Request.Method
+		registryErr.URL = response.Request.URL.String()
+	}
+
+	if response.Body == nil || response.Request != nil && response.Request.Method == http.MethodHead {
+		return registryErr
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxErrorBodySize))
+	if err != nil {
+		return registryErr
+	}
+
+	var decoded struct {
+		Errors []ErrorDetail `json:"errors"`
+	}
+	if json.Unmarshal(body, &decoded) == nil && len(decoded.Errors) > 0 {
+		registryErr.Errors = decoded.Errors
+		return registryErr
+	}
+
+	if _, ok := legacy.(InvalidStatusCodeError); ok {
+		if trimmed := strings.TrimSpace(string(body)); trimmed != "" {
+			if len(trimmed) > 2048 {
+				trimmed = trimmed[:2048]
+			}
+			registryErr.legacy = InvalidStatusCodeError(fmt.Sprintf("%s: %s", legacy, trimmed))
+		}
+	}
+
+	return registryErr
+}
@@ -0,0 +1,57 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/kspeeder/docker-registry/lib/registrytest"
+)
+
+func TestRegistryErrorCodes(t *testing.T) {
+	registry := newTestRegistry(registrytest.AuthToken)
+	defer registry.Close()
+
+	registry.AddImage("foo", "latest", []byte("layer"))
+	api := newTestApi(t, registry, nil)
+
+	_, err := api.GetTagDetails(context.Background(), NewRefspec("foo", "missing"), 2)
+
+	var registryErr *RegistryError
+	if !errors.As(err, &registryErr) || registryErr.StatusCode != http.StatusNotFound || registryErr.Method != http.MethodGet ||
+		!strings.HasSuffix(registryErr.URL, "/v2/foo/manifests/missing") {
+		t.Fatalf("expected a registry error for the manifest request, got %#v", err)
+	}
+
+	var notFound NotFoundError
+	if !errors.Is(err, ErrorCodeManifestUnknown) || errors.Is(err, ErrorCodeBlobUnknown) || !errors.As(err, &notFound) {
+		t.Fatalf("unexpected error classification of %v", err)
+	}
+
+	err = api.DeleteTag(NewRefspec("foo", "latest"))
+
+	var invalidRequest InvalidRequestError
+	if !errors.Is(err, ErrorCodeDigestInvalid) || !errors.As(err, &invalidRequest) {
+		t.Fatalf("unexpected error classification of %v", err)
+	}
+}
+
+func TestRegistryErrorKeepsPlainBody(t *testing.T) {
+	requestUrl, _ := url.Parse("https://registry.example/v2/")
+	response := &http.Response{
+		StatusCode: http.StatusBadGateway,
+		Body:       io.NopCloser(strings.NewReader("upstream unavailable\n")),
+		Request:    &http.Request{Method: http.MethodGet, URL: requestUrl},
+	}
+
+	err := newRegistryError(response, newInvalidStatusCodeError(response.StatusCode))
+
+	var statusErr InvalidStatusCodeError
+	if !errors.As(err, &statusErr) || err.Error() != "invalid API response status 502: upstream unavailable" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+package registrytest
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type accessEntry struct {
+	Type    string   `json:"type"`
+	Name    string   `json:"name"`
+	Actions []string `json:"actions"`
+}
+
+type issuedToken struct {
+	access  []accessEntry
+	revoked bool
+}
+
+func (t *issuedToken) grants(resourceType, name, action string) bool {
+	for _, entry := range t.access {
+		if entry.Type != resourceType || entry.Name != name {
+			continue
+		}
+
+		for _, granted := range entry.Actions {
+			if granted == action || granted == "*" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// RevokeTokens makes all tokens issued so far stale; the registry answers
+// requests carrying them with 401 and error="invalid_token".
+func (r *Registry) RevokeTokens() {
+	r.mutex.Lock()
+	for _, token := range r.tokens {
+		token.revoked = true
+	}
+	r.mutex.Unlock()
+}
+
+func (r *Registry) realm() string {
+	return r.server.URL + "/token"
+}
+
+// authorize checks the request credentials against the resource it targets
+// and writes the challenge if access is denied. An empty resourceType only
+// requires valid credentials, as for the /v2/ ping.
+func (r *Registry) authorize(w http.ResponseWriter, req *http.Request, resourceType, name, action string) bool {
+	switch r.options.Auth {
+	case AuthBasic:
+		user, password, ok := req.BasicAuth()
+		if ok && r.options.Users[user] == password && password != "" {
+			return true
+		}
+
+		if !ok && r.options.AnonymousPull && (action == "pull" || resourceType == "") {
+			return true
+		}
+
+		w.Header().Set("WWW-Authenticate", `Basic realm="registrytest"`)
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+		return false
+
+	case AuthToken:
+		var authError string
+
+		if value := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "); value != req.Header.Get("Authorization") {
+			r.mutex.Lock()
+			token, known := r.tokens[value]
+			r.mutex.Unlock()
+
+			switch {
+			case !known || token.revoked:
+				authError = "invalid_token"
+			case resourceType == "" || token.grants(resourceType, name, action):
+				return true
+			default:
+				authError = "insufficient_scope"
+			}
+		}
+
+		challenge := fmt.Sprintf(`Bearer realm="%s",service="%s"`, r.realm(), r.options.Service)
+		if resourceType != "" {
+			challenge += fmt.Sprintf(`,scope="%s:%s:%s"`, resourceType, name, action)
+		}
+		if authError != "" {
+			challenge += fmt.Sprintf(`,error="%s"`, authError)
+		}
+
+		w.Header().Set("WWW-Authenticate", challenge)
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+		return false
+
+	default:
+		return true
+	}
+}
+
+func (r *Registry) serveToken(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	r.tokenFetches++
+	r.mutex.Unlock()
+
+	var user, password string
+	var hasCredentials bool
+	var scopes []string
+	var refreshToken string
+
+	if req.Method == http.MethodPost {
+		req.ParseForm()
+
+		switch req.PostForm.Get("grant_type") {
+		case "password":
+			user, password, hasCredentials = req.PostForm.Get("username"), req.PostForm.Get("password"), true
+			if req.PostForm.Get("access_type") == "offline" {
+				refreshToken = "refresh-" + user
+			}
+		case "refresh_token":
+			user = strings.TrimPrefix(req.PostForm.Get("refresh_token"), "refresh-")
+			password, hasCredentials = r.options.Users[user], true
+		}
+
+		scopes = strings.Fields(req.PostForm.Get("scope"))
+	} else {
+		user, password, hasCredentials = req.BasicAuth()
+		for _, scope := range req.URL.Query()["scope"] {
+			scopes = append(scopes, strings.Fields(scope)...)
+		}
+	}
+
+	authenticated := hasCredentials && password != "" && r.options.Users[user] == password
+	if hasCredentials && !authenticated {
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "invalid credentials")
+		return
+	}
+
+	token := &issuedToken{}
+	for _, scope := range scopes {
+		pieces := strings.Split(scope, ":")
+		if len(pieces) < 3 {
+			continue
+		}
+
+		entry := accessEntry{
+			Type: pieces[0],
+			Name: strings.Join(pieces[1:len(pieces)-1], ":"),
+		}
+
+		for _, action := range strings.Split(pieces[len(pieces)-1], ",") {
+			if authenticated || (r.options.AnonymousPull && action == "pull") {
+				entry.Actions = append(entry.Actions, action)
+			}
+		}
+
+		if len(entry.Actions) > 0 {
+			token.access = append(token.access, entry)
+		}
+	}
+
+	value := encodeToken(token.access)
+
+	r.mutex.Lock()
+	r.tokens[value] = token
+	r.mutex.Unlock()
+
+	response := map[string]interface{}{
+		"token":        value,
+		"access_token": value,
+		"issued_at":    time.Now().UTC().Format(time.RFC3339),
+	}
+
+	if refreshToken != "" {
+		response["refresh_token"] = refreshToken
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// encodeToken produces an unsigned JWT carrying the granted access claims,
+// in the shape issued by the reference token server.
+func encodeToken(access []accessEntry) string {
+	nonce := make([]byte, 8)
+	rand.Read(nonce)
+
+	header, _ := json.Marshal(map[string]string{"alg": "none", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"access": access,
+		"jti":    hex.EncodeToString(nonce),
+		"iat":    time.Now().Unix(),
+	})
+
+	return strings.Join([]string{
+		base64.RawURLEncoding.EncodeToString(header),
+		base64.RawURLEncoding.EncodeToString(claims),
+		base64.RawURLEncoding.EncodeToString(nonce),
+	}, ".")
+}
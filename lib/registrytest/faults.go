@@ -0,0 +1,88 @@
+package registrytest
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Faults configures misbehavior of the fake registry.
+type Faults struct {
+	// RateLimitEvery answers every nth registry request with 429.
+	RateLimitEvery int
+	RetryAfter     time.Duration
+	// UnavailableEvery answers every nth registry request with 503.
+	UnavailableEvery int
+	// SlowBodyDelay pauses between body chunks of SlowBodyChunk bytes.
+	SlowBodyDelay time.Duration
+	SlowBodyChunk int
+	// IgnoreRange serves full blobs with 200 regardless of Range headers.
+	IgnoreRange bool
+}
+
+func (r *Registry) SetFaults(faults Faults) {
+	r.mutex.Lock()
+	r.faults = faults
+	r.mutex.Unlock()
+}
+
+func (r *Registry) currentFaults() Faults {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.faults
+}
+
+// injectFault answers the request if a status fault applies to it.
+func (r *Registry) injectFault(w http.ResponseWriter, sequence int) bool {
+	faults := r.currentFaults()
+
+	if faults.RateLimitEvery > 0 && sequence%faults.RateLimitEvery == 0 {
+		if faults.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(faults.RetryAfter.Seconds())))
+		}
+		writeError(w, http.StatusTooManyRequests, "TOOMANYREQUESTS", "too many requests")
+		return true
+	}
+
+	if faults.UnavailableEvery > 0 && sequence%faults.UnavailableEvery == 0 {
+		writeError(w, http.StatusServiceUnavailable, "UNAVAILABLE", "service unavailable")
+		return true
+	}
+
+	return false
+}
+
+func (r *Registry) writeBody(w http.ResponseWriter, body []byte) {
+	faults := r.currentFaults()
+
+	if faults.SlowBodyDelay <= 0 {
+		w.Write(body)
+		return
+	}
+
+	chunk := faults.SlowBodyChunk
+	if chunk <= 0 {
+		chunk = 1024
+	}
+
+	for len(body) > 0 {
+		n := chunk
+		if n > len(body) {
+			n = len(body)
+		}
+
+		if _, err := w.Write(body[:n]); err != nil {
+			return
+		}
+
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+
+		body = body[n:]
+		if len(body) > 0 {
+			time.Sleep(faults.SlowBodyDelay)
+		}
+	}
+}
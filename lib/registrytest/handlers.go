@@ -0,0 +1,497 @@
+package registrytest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// All blobs report the same modification time.
+var blobModTime = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]interface{}{
+			{
+				"code":    code,
+				"message": message,
+			},
+		},
+	})
+}
+
+func (r *Registry) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		r.serveToken(w, req)
+		return
+	}
+
+	if !strings.HasPrefix(req.URL.Path, "/v2/") {
+		http.NotFound(w, req)
+		return
+	}
+
+	r.mutex.Lock()
+	r.requests++
+	sequence := r.requests
+	r.mutex.Unlock()
+
+	if r.injectFault(w, sequence) {
+		return
+	}
+
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+
+	switch {
+	case path == "":
+		if r.authorize(w, req, "", "", "") {
+			w.WriteHeader(http.StatusOK)
+		}
+
+	case path == "_catalog":
+		r.serveCatalog(w, req)
+
+	case strings.HasSuffix(path, "/tags/list"):
+		r.serveTags(w, req, strings.TrimSuffix(path, "/tags/list"))
+
+	case strings.Contains(path, "/manifests/"):
+		i := strings.LastIndex(path, "/manifests/")
+		r.serveManifest(w, req, path[:i], path[i+len("/manifests/"):])
+
+	case strings.Contains(path, "/referrers/"):
+		i := strings.LastIndex(path, "/referrers/")
+		r.serveReferrers(w, req, path[:i])
+
+	case strings.Contains(path, "/blobs/uploads"):
+		i := strings.LastIndex(path, "/blobs/uploads")
+		r.serveUpload(w, req, path[:i], strings.Trim(path[i+len("/blobs/uploads"):], "/"))
+
+	case strings.Contains(path, "/blobs/"):
+		i := strings.LastIndex(path, "/blobs/")
+		r.serveBlob(w, req, path[:i], path[i+len("/blobs/"):])
+
+	default:
+		writeError(w, http.StatusNotFound, "NAME_UNKNOWN", "unknown endpoint")
+	}
+}
+
+func actionForMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return "pull"
+	case http.MethodDelete:
+		return "delete"
+	default:
+		return "push"
+	}
+}
+
+// paginate applies the n/last query parameters and sets the Link header if
+// more entries follow.
+func paginate(w http.ResponseWriter, req *http.Request, entries []string) []string {
+	query := req.URL.Query()
+
+	if last := query.Get("last"); last != "" {
+		start := sort.SearchStrings(entries, last)
+		if start < len(entries) && entries[start] == last {
+			start++
+		}
+		entries = entries[start:]
+	}
+
+	n, err := strconv.Atoi(query.Get("n"))
+	if err != nil || n <= 0 || n >= len(entries) {
+		return entries
+	}
+
+	next := url.URL{
+		Path: req.URL.Path,
+		RawQuery: url.Values{
+			"n":    {strconv.Itoa(n)},
+			"last": {entries[n-1]},
+		}.Encode(),
+	}
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.String()))
+
+	return entries[:n]
+}
+
+func writeJson(w http.ResponseWriter, document interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(document)
+}
+
+func (r *Registry) serveCatalog(w http.ResponseWriter, req *http.Request) {
+	if !r.authorize(w, req, "registry", "catalog", "*") {
+		return
+	}
+
+	if r.options.DisableCatalog {
+		writeError(w, http.StatusNotFound, "UNSUPPORTED", "catalog is disabled")
+		return
+	}
+
+	r.mutex.Lock()
+	names := r.repositoryNames()
+	r.mutex.Unlock()
+
+	writeJson(w, map[string]interface{}{
+		"repositories": paginate(w, req, names),
+	})
+}
+
+func (r *Registry) serveTags(w http.ResponseWriter, req *http.Request, name string) {
+	if !r.authorize(w, req, "repository", name, "pull") {
+		return
+	}
+
+	r.mutex.Lock()
+	repo := r.repository(name, false)
+	var tags []string
+	if repo != nil {
+		for tag := range repo.tags {
+			tags = append(tags, tag)
+		}
+	}
+	r.mutex.Unlock()
+
+	if repo == nil {
+		writeError(w, http.StatusNotFound, "NAME_UNKNOWN", "repository name not known to registry")
+		return
+	}
+
+	sort.Strings(tags)
+	writeJson(w, map[string]interface{}{
+		"name": name,
+		"tags": paginate(w, req, tags),
+	})
+}
+
+func (r *Registry) serveManifest(w http.ResponseWriter, req *http.Request, name, reference string) {
+	if !r.authorize(w, req, "repository", name, actionForMethod(req.Method)) {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	repo := r.repository(name, req.Method == http.MethodPut)
+	if repo == nil {
+		writeError(w, http.StatusNotFound, "NAME_UNKNOWN", "repository name not known to registry")
+		return
+	}
+
+	dgst, err := digest.Parse(reference)
+	isDigest := err == nil
+	if !isDigest {
+		dgst = repo.tags[reference]
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		stored, ok := repo.manifests[dgst]
+		if !ok {
+			writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+			return
+		}
+
+		w.Header().Set("Content-Type", stored.mediaType)
+		w.Header().Set("Docker-Content-Digest", dgst.String())
+		w.Header().Set("Content-Length", strconv.Itoa(len(stored.content)))
+		w.WriteHeader(http.StatusOK)
+
+		if req.Method == http.MethodGet {
+			w.Write(stored.content)
+		}
+
+	case http.MethodPut:
+		content, _ := io.ReadAll(req.Body)
+		dgst = digest.FromBytes(content)
+		repo.manifests[dgst] = manifest{
+			mediaType: req.Header.Get("Content-Type"),
+			content:   content,
+		}
+
+		if !isDigest {
+			repo.tags[reference] = dgst
+		}
+
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/manifests/%s", name, dgst))
+		w.Header().Set("Docker-Content-Digest", dgst.String())
+		w.WriteHeader(http.StatusCreated)
+
+	case http.MethodDelete:
+		if r.options.DisableDelete {
+			writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "deletion is disabled")
+			return
+		}
+
+		if !isDigest {
+			writeError(w, http.StatusBadRequest, "DIGEST_INVALID", "manifests can only be deleted by digest")
+			return
+		}
+
+		if _, ok := repo.manifests[dgst]; !ok {
+			writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+			return
+		}
+
+		delete(repo.manifests, dgst)
+		for tag, tagged := range repo.tags {
+			if tagged == dgst {
+				delete(repo.tags, tag)
+			}
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *Registry) serveReferrers(w http.ResponseWriter, req *http.Request, name string) {
+	if !r.authorize(w, req, "repository", name, "pull") {
+		return
+	}
+
+	if !r.options.Referrers {
+		writeError(w, http.StatusNotFound, "UNSUPPORTED", "referrers API is not supported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.oci.image.index.v1+json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.index.v1+json",
+		"manifests":     []interface{}{},
+	})
+}
+
+func (r *Registry) serveBlob(w http.ResponseWriter, req *http.Request, name, reference string) {
+	if !r.authorize(w, req, "repository", name, actionForMethod(req.Method)) {
+		return
+	}
+
+	r.mutex.Lock()
+	var content []byte
+	var ok bool
+	if repo := r.repository(name, false); repo != nil {
+		content, ok = repo.blobs[digest.Digest(reference)]
+
+		if ok && req.Method == http.MethodDelete && !r.options.DisableDelete {
+			delete(repo.blobs, digest.Digest(reference))
+		}
+	}
+	r.mutex.Unlock()
+
+	if req.Method == http.MethodDelete && r.options.DisableDelete {
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "deletion is disabled")
+		return
+	}
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown to registry")
+		return
+	}
+
+	switch req.Method {
+	case http.MethodDelete:
+		w.WriteHeader(http.StatusAccepted)
+		return
+
+	case http.MethodGet, http.MethodHead:
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.storage != nil && req.Method == http.MethodGet {
+		http.Redirect(w, req, r.storageURL(reference), http.StatusTemporaryRedirect)
+		return
+	}
+
+	ignoreRange := r.currentFaults().IgnoreRange
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", reference)
+	w.Header().Set("Last-Modified", blobModTime.Format(http.TimeFormat))
+	if !ignoreRange {
+		w.Header().Set("Accept-Ranges", "bytes")
+	}
+
+	status := http.StatusOK
+	if rangeHeader := req.Header.Get("Range"); rangeHeader != "" && !ignoreRange {
+		start, end, valid := parseRange(rangeHeader, int64(len(content)))
+		if !valid {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", len(content)))
+			writeError(w, http.StatusRequestedRangeNotSatisfiable, "BLOB_UNKNOWN", "range not satisfiable")
+			return
+		}
+
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+		content = content[start : end+1]
+		status = http.StatusPartialContent
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.WriteHeader(status)
+
+	if req.Method == http.MethodGet {
+		r.writeBody(w, content)
+	}
+}
+
+// parseRange understands the single "bytes=start-end" and "bytes=start-"
+// forms and returns an inclusive interval.
+func parseRange(header string, size int64) (start, end int64, valid bool) {
+	spec := strings.TrimPrefix(header, "bytes=")
+	if spec == header || strings.Contains(spec, ",") {
+		return
+	}
+
+	pieces := strings.SplitN(spec, "-", 2)
+	if len(pieces) != 2 {
+		return
+	}
+
+	start, err := strconv.ParseInt(pieces[0], 10, 64)
+	if err != nil || start >= size {
+		return
+	}
+
+	end = size - 1
+	if pieces[1] != "" {
+		end, err = strconv.ParseInt(pieces[1], 10, 64)
+		if err != nil || end < start {
+			return
+		}
+
+		if end >= size {
+			end = size - 1
+		}
+	}
+
+	valid = true
+	return
+}
+
+func newUploadId() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
+
+func (r *Registry) serveUpload(w http.ResponseWriter, req *http.Request, name, id string) {
+	if !r.authorize(w, req, "repository", name, "push") {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	query := req.URL.Query()
+
+	if id == "" {
+		if req.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if mount, from := query.Get("mount"), query.Get("from"); mount != "" && from != "" {
+			if source := r.repository(from, false); source != nil {
+				if content, ok := source.blobs[digest.Digest(mount)]; ok {
+					r.repository(name, true).blobs[digest.Digest(mount)] = content
+					w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", name, mount))
+					w.Header().Set("Docker-Content-Digest", mount)
+					w.WriteHeader(http.StatusCreated)
+					return
+				}
+			}
+		}
+
+		if expected := query.Get("digest"); expected != "" {
+			content, _ := io.ReadAll(req.Body)
+			r.completeUpload(w, name, expected, content)
+			return
+		}
+
+		id = newUploadId()
+		r.uploads[id] = &upload{
+			repository: name,
+		}
+
+		if r.options.ChunkMinLength > 0 {
+			w.Header().Set("OCI-Chunk-Min-Length", strconv.Itoa(r.options.ChunkMinLength))
+		}
+		writeUploadStatus(w, name, id, 0, http.StatusAccepted)
+		return
+	}
+
+	pending, ok := r.uploads[id]
+	if !ok || pending.repository != name {
+		writeError(w, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", "blob upload unknown to registry")
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		writeUploadStatus(w, name, id, len(pending.data), http.StatusNoContent)
+
+	case http.MethodPatch:
+		content, _ := io.ReadAll(req.Body)
+		pending.data = append(pending.data, content...)
+		writeUploadStatus(w, name, id, len(pending.data), http.StatusAccepted)
+
+	case http.MethodPut:
+		content, _ := io.ReadAll(req.Body)
+		delete(r.uploads, id)
+		r.completeUpload(w, name, query.Get("digest"), append(pending.data, content...))
+
+	case http.MethodDelete:
+		delete(r.uploads, id)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func writeUploadStatus(w http.ResponseWriter, name, id string, size int, status int) {
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", name, id))
+	w.Header().Set("Docker-Upload-UUID", id)
+	w.Header().Set("Range", fmt.Sprintf("0-%d", max(size-1, 0)))
+	w.WriteHeader(status)
+}
+
+// completeUpload must be called with the registry mutex held.
+func (r *Registry) completeUpload(w http.ResponseWriter, name, expected string, content []byte) {
+	dgst := digest.FromBytes(content)
+	if expected != dgst.String() {
+		writeError(w, http.StatusBadRequest, "DIGEST_INVALID", "provided digest did not match uploaded content")
+		return
+	}
+
+	r.repository(name, true).blobs[dgst] = content
+
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", name, dgst))
+	w.Header().Set("Docker-Content-Digest", dgst.String())
+	w.WriteHeader(http.StatusCreated)
+}
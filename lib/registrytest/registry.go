@@ -0,0 +1,247 @@
+// Package registrytest provides an in-memory implementation of the
+// distribution API for tests.
+package registrytest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	MediaTypeManifestV2 = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeLayer      = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	MediaTypeConfig     = "application/vnd.docker.container.image.v1+json"
+)
+
+type AuthMode int
+
+const (
+	AuthNone AuthMode = iota
+	AuthBasic
+	AuthToken
+)
+
+type Options struct {
+	Auth AuthMode
+	// Users maps user names to passwords. Anonymous clients are only granted
+	// pull access when AnonymousPull is set.
+	Users         map[string]string
+	AnonymousPull bool
+	Service       string
+	// DisableCatalog answers catalog requests with 404.
+	DisableCatalog bool
+	// DisableDelete answers deletes with 405 UNSUPPORTED.
+	DisableDelete bool
+	// Referrers enables the OCI referrers API.
+	Referrers bool
+	// ChunkMinLength is announced as OCI-Chunk-Min-Length on new uploads.
+	ChunkMinLength int
+	// RedirectBlobs answers blob GETs with redirects to a storage server on
+	// another host.
+	RedirectBlobs bool
+	// PresignExpiresIn is the lifetime in seconds of the storage URLs, which
+	// are presigned like S3 URLs if it is set.
+	PresignExpiresIn int
+}
+
+type manifest struct {
+	mediaType string
+	content   []byte
+}
+
+type repository struct {
+	tags      map[string]digest.Digest
+	manifests map[digest.Digest]manifest
+	blobs     map[digest.Digest][]byte
+}
+
+type upload struct {
+	repository string
+	data       []byte
+}
+
+// Registry is a fake registry served by an httptest.Server.
+type Registry struct {
+	server       *httptest.Server
+	storage      *httptest.Server
+	options      Options
+	faults       Faults
+	repositories map[string]*repository
+	uploads      map[string]*upload
+	tokens       map[string]*issuedToken
+	requests     int
+	tokenFetches int
+	// Requests served by the storage server, and those of them carrying
+	// credentials.
+	storageRequests       int
+	storageAuthorizations int
+	// storageGeneration is part of presigned URLs; bumping it revokes them.
+	storageGeneration int
+	mutex             sync.Mutex
+}
+
+func (r *Registry) URL() *url.URL {
+	parsed, _ := url.Parse(r.server.URL)
+	return parsed
+}
+
+func (r *Registry) Client() *http.Client {
+	return r.server.Client()
+}
+
+func (r *Registry) Close() {
+	r.server.Close()
+	if r.storage != nil {
+		r.storage.Close()
+	}
+}
+
+// Requests returns the number of requests served by the registry endpoints.
+func (r *Registry) Requests() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.requests
+}
+
+// TokenRequests returns the number of requests served by the token endpoint.
+func (r *Registry) TokenRequests() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.tokenFetches
+}
+
+func (r *Registry) repository(name string, create bool) *repository {
+	repo, ok := r.repositories[name]
+	if !ok && create {
+		repo = &repository{
+			tags:      make(map[string]digest.Digest),
+			manifests: make(map[digest.Digest]manifest),
+			blobs:     make(map[digest.Digest][]byte),
+		}
+		r.repositories[name] = repo
+	}
+
+	return repo
+}
+
+func (r *Registry) repositoryNames() []string {
+	names := make([]string, 0, len(r.repositories))
+	for name := range r.repositories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (r *Registry) AddBlob(repositoryName string, content []byte) string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	dgst := digest.FromBytes(content)
+	r.repository(repositoryName, true).blobs[dgst] = content
+
+	return dgst.String()
+}
+
+// AddManifest stores a manifest and, if tag is non-empty, tags it.
+func (r *Registry) AddManifest(repositoryName, tag, mediaType string, content []byte) string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	dgst := digest.FromBytes(content)
+	repo := r.repository(repositoryName, true)
+	repo.manifests[dgst] = manifest{
+		mediaType: mediaType,
+		content:   content,
+	}
+
+	if tag != "" {
+		repo.tags[tag] = dgst
+	}
+
+	return dgst.String()
+}
+
+// AddImage stores the given layers plus an empty config and tags a schema 2
+// manifest referencing them. It returns the manifest digest and the layer
+// digests in order.
+func (r *Registry) AddImage(repositoryName, tag string, layers ...[]byte) (manifestDigest string, layerDigests []string) {
+	type descriptor struct {
+		MediaType string `json:"mediaType"`
+		Digest    string `json:"digest"`
+		Size      int    `json:"size"`
+	}
+
+	config := []byte("{}")
+	document := struct {
+		SchemaVersion int          `json:"schemaVersion"`
+		MediaType     string       `json:"mediaType"`
+		Config        descriptor   `json:"config"`
+		Layers        []descriptor `json:"layers"`
+	}{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeManifestV2,
+		Config: descriptor{
+			MediaType: MediaTypeConfig,
+			Digest:    r.AddBlob(repositoryName, config),
+			Size:      len(config),
+		},
+	}
+
+	for _, layer := range layers {
+		layerDigest := r.AddBlob(repositoryName, layer)
+		layerDigests = append(layerDigests, layerDigest)
+		document.Layers = append(document.Layers, descriptor{
+			MediaType: MediaTypeLayer,
+			Digest:    layerDigest,
+			Size:      len(layer),
+		})
+	}
+
+	content, _ := json.Marshal(document)
+	manifestDigest = r.AddManifest(repositoryName, tag, MediaTypeManifestV2, content)
+
+	return
+}
+
+// Blob returns the content of a blob, e.g. to verify uploads.
+func (r *Registry) Blob(repositoryName, dgst string) (content []byte, ok bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if repo := r.repository(repositoryName, false); repo != nil {
+		content, ok = repo.blobs[digest.Digest(dgst)]
+	}
+
+	return
+}
+
+// New starts a fake registry. Close it when done.
+func New(options Options) *Registry {
+	if options.Service == "" {
+		options.Service = "registrytest"
+	}
+
+	r := &Registry{
+		options:      options,
+		repositories: make(map[string]*repository),
+		uploads:      make(map[string]*upload),
+		tokens:       make(map[string]*issuedToken),
+	}
+
+	r.server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	if options.RedirectBlobs {
+		r.storage = httptest.NewServer(http.HandlerFunc(r.serveStorage))
+	}
+
+	return r
+}
@@ -0,0 +1,114 @@
+package registrytest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func get(t *testing.T, registry *Registry, path, token string, header http.Header) (*http.Response, []byte) {
+	request, err := http.NewRequest(http.MethodGet, registry.URL().String()+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	response, err := registry.Client().Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return response, body
+}
+
+func fetchToken(t *testing.T, registry *Registry, scope string) string {
+	request, _ := http.NewRequest(http.MethodGet, registry.realm()+"?service=registrytest&scope="+scope, nil)
+	request.SetBasicAuth("user", "password")
+
+	response, err := registry.Client().Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	var document struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&document); err != nil || document.Token == "" {
+		t.Fatalf("no token issued: %v", err)
+	}
+
+	return document.Token
+}
+
+func TestTokenAuthPull(t *testing.T) {
+	registry := New(Options{
+		Auth:  AuthToken,
+		Users: map[string]string{"user": "password"},
+	})
+	defer registry.Close()
+
+	manifestDigest, layers := registry.AddImage("foo", "latest", []byte("layer content"))
+
+	response, _ := get(t, registry, "/v2/foo/manifests/latest", "", nil)
+	if response.StatusCode != http.StatusUnauthorized ||
+		!strings.Contains(response.Header.Get("WWW-Authenticate"), `scope="repository:foo:pull"`) {
+		t.Fatalf("expected a pull challenge, got %d %q", response.StatusCode, response.Header.Get("WWW-Authenticate"))
+	}
+
+	token := fetchToken(t, registry, "repository:foo:pull")
+
+	response, body := get(t, registry, "/v2/foo/manifests/latest", token, nil)
+	if response.StatusCode != http.StatusOK || digest.FromBytes(body).String() != manifestDigest {
+		t.Fatalf("unexpected manifest response %d", response.StatusCode)
+	}
+
+	response, body = get(t, registry, "/v2/foo/blobs/"+layers[0], token, http.Header{"Range": {"bytes=6-12"}})
+	if response.StatusCode != http.StatusPartialContent || string(body) != "content" {
+		t.Fatalf("unexpected range response %d %q", response.StatusCode, body)
+	}
+
+	if response, _ = get(t, registry, "/v2/bar/tags/list", token, nil); response.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("a token for foo must not grant access to bar, got %d", response.StatusCode)
+	}
+
+	registry.RevokeTokens()
+	response, _ = get(t, registry, "/v2/foo/tags/list", token, nil)
+	if !strings.Contains(response.Header.Get("WWW-Authenticate"), `error="invalid_token"`) {
+		t.Fatalf("expected a revoked token to be rejected, got %q", response.Header.Get("WWW-Authenticate"))
+	}
+
+	if fetches := registry.TokenRequests(); fetches != 1 {
+		t.Fatalf("expected one token request, got %d", fetches)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	registry := New(Options{})
+	defer registry.Close()
+
+	registry.SetFaults(Faults{RateLimitEvery: 2})
+
+	if response, _ := get(t, registry, "/v2/", "", nil); response.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected ping status %d", response.StatusCode)
+	}
+	if response, _ := get(t, registry, "/v2/", "", nil); response.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected the second request to be rate limited, got %d", response.StatusCode)
+	}
+}
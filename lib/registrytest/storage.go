@@ -0,0 +1,99 @@
+package registrytest
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// serveStorage serves blobs on a host of its own, like the object storage
+// registries redirect blob downloads to.
+func (r *Registry) serveStorage(w http.ResponseWriter, req *http.Request) {
+	reference := digest.Digest(strings.TrimPrefix(req.URL.Path, "/blobs/"))
+
+	r.mutex.Lock()
+	r.storageRequests++
+	if req.Header.Get("Authorization") != "" {
+		r.storageAuthorizations++
+	}
+
+	if r.options.PresignExpiresIn > 0 && !r.validSignature(req.URL.Query()) {
+		r.mutex.Unlock()
+		http.Error(w, "request has expired", http.StatusForbidden)
+		return
+	}
+
+	var content []byte
+	var ok bool
+	for _, repo := range r.repositories {
+		if content, ok = repo.blobs[reference]; ok {
+			break
+		}
+	}
+	r.mutex.Unlock()
+
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, req, "", blobModTime, bytes.NewReader(content))
+}
+
+// StorageRequests returns the number of requests served by the blob storage.
+func (r *Registry) StorageRequests() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.storageRequests
+}
+
+// StorageAuthorizations returns the number of storage requests that carried
+// an Authorization header.
+func (r *Registry) StorageAuthorizations() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.storageAuthorizations
+}
+
+const presignDateFormat = "20060102T150405Z"
+
+func (r *Registry) storageURL(reference string) string {
+	storageUrl := r.storage.URL + "/blobs/" + reference
+	if r.options.PresignExpiresIn <= 0 {
+		return storageUrl
+	}
+
+	r.mutex.Lock()
+	generation := r.storageGeneration
+	r.mutex.Unlock()
+
+	return storageUrl + "?" + url.Values{
+		"X-Amz-Date":      {time.Now().UTC().Format(presignDateFormat)},
+		"X-Amz-Expires":   {strconv.Itoa(r.options.PresignExpiresIn)},
+		"X-Amz-Signature": {strconv.Itoa(generation)},
+	}.Encode()
+}
+
+func (r *Registry) validSignature(query url.Values) bool {
+	signedAt, err := time.Parse(presignDateFormat, query.Get("X-Amz-Date"))
+	if err != nil || query.Get("X-Amz-Signature") != strconv.Itoa(r.storageGeneration) {
+		return false
+	}
+
+	return time.Since(signedAt) < time.Duration(r.options.PresignExpiresIn)*time.Second
+}
+
+// RevokeStorageURLs makes storage reject all presigned URLs issued so far.
+func (r *Registry) RevokeStorageURLs() {
+	r.mutex.Lock()
+	r.storageGeneration++
+	r.mutex.Unlock()
+}